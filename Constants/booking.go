@@ -0,0 +1,3 @@
+package Constants
+
+const PublicBookingURL string = "https://physioup.ddns.net"
@@ -0,0 +1,71 @@
+package Controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"PhysioUp/Models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FetchAuditLogs lists the changes made in the clinic group, newest first.
+// It can be filtered by entity (table name) and record_id, actor_id, action,
+// request_id and a date_from/date_to range, and is paged with limit and
+// offset.
+func FetchAuditLogs(c *gin.Context) {
+	limit, offset, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	query := getScopedDB(c).Model(&Models.AuditLog{})
+	if value := c.Query("entity"); value != "" {
+		query = query.Where("entity = ?", value)
+	}
+	for _, column := range []string{"record_id", "actor_id"} {
+		value := c.Query(column)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + column})
+			return
+		}
+		query = query.Where(column+" = ?", id)
+	}
+	if value := c.Query("action"); value != "" {
+		if value != Models.AuditCreate && value != Models.AuditUpdate && value != Models.AuditDelete {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid action, use create, update or delete"})
+			return
+		}
+		query = query.Where("action = ?", value)
+	}
+	if value := c.Query("request_id"); value != "" {
+		query = query.Where("request_id = ?", value)
+	}
+	if query, ok = createdBetween(c, query); !ok {
+		return
+	}
+
+	// Count and Find both build on the filters
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit log"})
+		return
+	}
+	logs := []Models.AuditLog{}
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&logs).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total": total, "logs": logs})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"PhysioUp/Models"
@@ -11,8 +12,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	jwt "github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func CurrentUser(c *gin.Context) {
@@ -30,15 +31,21 @@ func CurrentUser(c *gin.Context) {
 		return
 	}
 	var output struct {
-		ID            uint   `json:"ID"`
-		Username      string `json:"username"`
-		ClinicName    string `json:"clinic_name"`
-		Permission    int    `json:"permission"`
-		ClinicGroupID uint   `json:"clinic_group_id"`
+		ID            uint                `json:"ID"`
+		Username      string              `json:"username"`
+		ClinicName    string              `json:"clinic_name"`
+		Permission    int                 `json:"permission"`
+		ClinicGroupID uint                `json:"clinic_group_id"`
+		Role          Models.Role         `json:"role"`
+		Permissions   []Models.Permission `json:"permissions"`
+		Phone         string              `json:"phone"`
+		// Set after an owner reset until the user picks their own password
+		MustChangePassword bool `json:"must_change_password"`
+		TOTPEnabled        bool `json:"totp_enabled"`
 	}
 	// if user.Permission == 1 {
 	// 	var doctor Models.Doctor
-	// 	if err := Models.DB.Model(&Models.Doctor{}).Where("user_id = ?", user.ID).Find(&doctor).Error; err != nil {
+	// 	if err := getScopedDB(c).Model(&Models.Doctor{}).Where("user_id = ?", user.ID).Find(&doctor).Error; err != nil {
 	// 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	// 		return
 	// 	}
@@ -48,6 +55,11 @@ func CurrentUser(c *gin.Context) {
 	output.Username = user.Username
 	output.Permission = user.Permission
 	output.ClinicGroupID = user.ClinicGroupID
+	output.Role = user.Role
+	output.Permissions = user.Role.Permissions()
+	output.Phone = user.Phone
+	output.MustChangePassword = user.MustChangePassword
+	output.TOTPEnabled = user.TOTPEnabled
 	c.JSON(http.StatusOK, gin.H{"message": "success", "data": output})
 }
 
@@ -56,52 +68,132 @@ type LoginInput struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// Logout ends the caller's session, revoking its refresh token and removing
+// the device token registered through it.
 func Logout(c *gin.Context) {
-	token, err := Token.ExtractJWT(c)
+	sessionID, err := Token.ExtractSessionID(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	claims := jwt.MapClaims{}
-	claims["authorized"] = false
-	claims["exp"] = time.Now()
-	token2 := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	token.Claims = token2.Claims
+	if err := Models.RevokeUserSession(Models.DB, sessionID); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged Out Successfully"})
 }
 
+// SaveFCM registers the device's notification token for the caller and ties
+// it to their session, so logging out stops the notifications. The app can
+// also say which platform it runs on for the sessions list.
 func SaveFCM(c *gin.Context) {
 	var input struct {
-		Token string `json:"token"`
+		Token    string `json:"token" binding:"required"`
+		Platform string `json:"platform"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	c.ShouldBindJSON(&input)
 	user_id, err := Token.ExtractTokenID(c)
 	if err != nil {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		// return
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+
+	// A device that changes hands moves to the new user
 	deviceToken := Models.DeviceToken{UserID: user_id, Value: input.Token}
-	if err := Models.DB.Save(&deviceToken).Error; err != nil {
-		// c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		// return
+	if err := Models.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "value"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "updated_at", "deleted_at"}),
+	}).Create(&deviceToken).Error; err != nil {
+		log.Println(err)
+	}
+
+	if sessionID, err := Token.ExtractSessionID(c); err == nil {
+		if err := Models.AttachDeviceToken(sessionID, input.Token, input.Platform); err != nil {
+			log.Println(err)
+		}
 	}
 	c.JSON(http.StatusOK, nil)
 }
 
+// respondWithSession returns a new access token for the session along with
+// its refresh token.
+func respondWithSession(c *gin.Context, user Models.User, session Models.UserSession, refreshToken string, message string) {
+	respondWithSessionExtra(c, user, session, refreshToken, message, nil)
+}
+
+func respondWithSessionExtra(c *gin.Context, user Models.User, session Models.UserSession, refreshToken string, message string, extra gin.H) {
+	accessToken, lifespan, err := Token.GenerateToken(user.ID, session.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	response := gin.H{
+		"message":       message,
+		"jwt":           accessToken,
+		"expires_in":    int(lifespan.Seconds()),
+		"refresh_token": refreshToken,
+		"permission":    user.Permission,
+		"role":          user.Role,
+		// Set after an owner reset, the app should ask for a new password
+		"must_change_password": user.MustChangePassword,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// recordLoginAttempt stores the attempt for the owners' login history.
+func recordLoginAttempt(c *gin.Context, username string, success bool, reason string) {
+	if err := Models.RecordLoginAttempt(username, c.ClientIP(), c.Request.UserAgent(), success, reason); err != nil {
+		log.Println(err)
+	}
+}
+
+// loginThrottled responds with 429 while the username or the caller's IP
+// address has too many recent failed logins.
+func loginThrottled(c *gin.Context, username string) bool {
+	retryAt, err := Models.CheckLoginThrottle(username, c.ClientIP())
+	if err == nil {
+		return false
+	}
+	if !errors.Is(err, Models.ErrLoginThrottled) {
+		// Logins aren't blocked because the history can't be read
+		log.Println(err)
+		return false
+	}
+	recordLoginAttempt(c, username, false, Models.LoginFailedLocked)
+	retryAfter := int(time.Until(retryAt).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later", "retry_after": retryAfter})
+	return true
+}
+
 func Login(c *gin.Context) {
 	var input LoginInput
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if loginThrottled(c, input.Username) {
+		return
+	}
 
 	user := Models.User{}
 
 	user.Username = input.Username
 	user.Password = input.Password
 
-	uid, token, err := Models.LoginCheck(user.Username, user.Password)
+	uid, err := Models.LoginCheck(user.Username, user.Password)
 
+	// Unknown usernames and wrong passwords get the same answer
 	if err != nil {
+		recordLoginAttempt(c, input.Username, false, Models.LoginFailedPassword)
 		c.JSON(http.StatusBadRequest, gin.H{"message": "username or password is incorrect."})
 		return
 	}
@@ -109,11 +201,70 @@ func Login(c *gin.Context) {
 	user, _ = Models.GetUserByID(uid)
 
 	if user.IsFrozen {
+		recordLoginAttempt(c, input.Username, false, Models.LoginFailedFrozen)
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "User Frozen"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Login Successful", "jwt": token, "permission": user.Permission})
 
+	// The session is only created once the second step passes, see
+	// VerifyLoginTOTP and ConfirmLoginTOTPEnrolment
+	if Models.TOTPRequired(user) {
+		loginToken, err := Token.GenerateLoginChallengeToken(user.ID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":                 "Two-factor authentication required",
+			"totp_required":           user.TOTPEnabled,
+			"totp_enrolment_required": !user.TOTPEnabled,
+			"login_token":             loginToken,
+		})
+		return
+	}
+
+	session, refreshToken, err := Models.CreateUserSession(Models.DB, user.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	recordLoginAttempt(c, input.Username, true, "")
+	respondWithSession(c, user, session, refreshToken, "Login Successful")
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working.
+func RefreshToken(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, refreshToken, err := Models.RotateRefreshToken(input.RefreshToken, c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, Models.ErrSessionInvalid):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, log in again"})
+		case errors.Is(err, Models.ErrUserFrozen):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User Frozen"})
+		default:
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		}
+		return
+	}
+
+	user, err := Models.GetUserByID(session.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, log in again"})
+		return
+	}
+	respondWithSession(c, user, session, refreshToken, "Session Refreshed")
 }
 
 type RegisterInput struct {
@@ -123,33 +274,53 @@ type RegisterInput struct {
 	ClinicGroupID uint   `json:"clinic_group_id"`
 }
 
+// Register signs up a new user by redeeming an invite, which sets their role
+// and clinic group.
 func Register(c *gin.Context) {
-	var input RegisterInput
+	var input struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Token    string `json:"token" binding:"required"`
+		// Optional, needed to reset a forgotten password
+		PhoneNumber string `json:"phone_number"`
+	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := Models.ValidatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be 8 to 72 characters"})
+		return
+	}
+	if input.PhoneNumber != "" {
+		input.PhoneNumber = normalizePhone(input.PhoneNumber)
+	}
 
-	user := Models.User{}
-
-	user.Username = input.Username
-	user.Password = input.Password
-	user.Permission = input.Permission
-	user.ClinicGroupID = input.ClinicGroupID // Don't forget to set this field
-	_, err := user.SaveUser()
-
+	user, err := Models.RedeemInvite(input.Token, input.Username, input.Password, input.PhoneNumber)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		switch {
+		case errors.Is(err, Models.ErrInviteInvalid):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invite is invalid, already used or expired"})
+		case errors.Is(err, gorm.ErrDuplicatedKey):
+			c.JSON(http.StatusConflict, gin.H{"error": "Username already taken"})
+		default:
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		}
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": "validated"})
+	c.JSON(http.StatusOK, gin.H{"message": "Registered Successfully", "user_id": user.ID, "role": user.Role})
 }
 
+// RegisterClinicGroup creates a clinic group with its first owner. It is
+// guarded by Middleware.RequireAdminKey.
 func RegisterClinicGroup(c *gin.Context) {
 	var input struct {
 		Name     string `json:"name"`
 		Password string `json:"password"`
+		TimeZone string `json:"time_zone"`
+		Slug     string `json:"slug"`
 	}
 
 	var group Models.ClinicGroup
@@ -159,7 +330,29 @@ func RegisterClinicGroup(c *gin.Context) {
 		return
 	}
 
+	if input.TimeZone != "" {
+		if _, err := time.LoadLocation(input.TimeZone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone, use an IANA name such as Africa/Cairo"})
+			return
+		}
+	}
+
+	if input.Slug == "" {
+		slug, err := Models.UniqueClinicGroupSlug(Models.DB, input.Name)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create clinic group"})
+			return
+		}
+		input.Slug = slug
+	} else if !Models.ValidSlug(input.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Slug must be 3 to 63 lowercase letters, digits or single hyphens"})
+		return
+	}
+
 	group.Name = input.Name
+	group.TimeZone = input.TimeZone
+	group.Slug = input.Slug
 
 	if err := Models.DB.Create(&group).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -170,7 +363,8 @@ func RegisterClinicGroup(c *gin.Context) {
 
 	user.Username = input.Name
 	user.Password = input.Password
-	user.Permission = 3
+	user.Role = Models.RoleOwner
+	user.Permission = user.Role.LegacyPermission()
 	user.ClinicGroupID = group.ID
 	_, err := user.SaveUser()
 	if err != nil {
@@ -190,6 +384,10 @@ func RegisterTherapist(c *gin.Context) {
 		c.Abort()
 		return
 	}
+	if err := Models.ValidatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be 8 to 72 characters"})
+		return
+	}
 
 	user_id, _ := Token.ExtractTokenID(c)
 
@@ -210,11 +408,24 @@ func RegisterTherapist(c *gin.Context) {
 		}
 	}
 
+	var therapist Models.Therapist
+
+	if err := c.ShouldBindBodyWith(&therapist, binding.JSON); err != nil {
+		log.Println(err.Error())
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+	if therapist.BranchID != nil && !clinicRecordExists(c, &Models.Branch{}, *therapist.BranchID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+
 	user := Models.User{}
 
 	user.Username = input.Username
 	user.Password = input.Password
-	user.Permission = 2
+	user.Role = Models.RoleTherapist
+	user.Permission = user.Role.LegacyPermission()
 	user.ClinicGroupID = input.ClinicGroupID
 	_, err = user.SaveUser()
 
@@ -225,18 +436,11 @@ func RegisterTherapist(c *gin.Context) {
 		return
 	}
 
-	var therapist Models.Therapist
-
-	if err := c.ShouldBindBodyWith(&therapist, binding.JSON); err != nil {
-		log.Println(err.Error())
-		c.JSON(http.StatusBadRequest, err)
-		return
-	}
 	therapist.UserID = user.ID
 	therapist.Schedule = Models.Schedule{TherapistID: therapist.UserID}
 	therapist.Name = "Dr. " + input.Username
 	// Models.CreateDoctorWorkingHours(&doctor)
-	if err := Models.DB.Model(&Models.Therapist{}).Create(&therapist).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Create(&therapist).Error; err != nil {
 		log.Println(err)
 		c.JSON(http.StatusBadRequest, err)
 		return
@@ -257,7 +461,7 @@ func DeleteTherapist(c *gin.Context) {
 	}
 
 	var therapist Models.Therapist
-	if err := Models.DB.Where("id = ?", input.ID).First(&therapist).Error; err != nil {
+	if err := getScopedDB(c).Where("id = ?", input.ID).First(&therapist).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
 		} else {
@@ -267,7 +471,7 @@ func DeleteTherapist(c *gin.Context) {
 	}
 
 	var user Models.User
-	if err := Models.DB.Where("id = ?", therapist.UserID).First(&user).Error; err != nil {
+	if err := getScopedDB(c).Where("id = ?", therapist.UserID).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Associated user not found"})
 		} else {
@@ -276,7 +480,7 @@ func DeleteTherapist(c *gin.Context) {
 		return
 	}
 
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
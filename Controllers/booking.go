@@ -0,0 +1,42 @@
+package Controllers
+
+import (
+	"errors"
+
+	"PhysioUp/Models"
+
+	"gorm.io/gorm"
+)
+
+// bookSession checks leave, closures and the branch's opening hours,
+// allocates the resources the session needs and books the slot on the
+// therapist's schedule inside tx.
+func bookSession(tx *gorm.DB, therapist Models.Therapist, schedule Models.Schedule, clinicGroupID uint, slot Models.Slot, requirement Models.ResourceRequirement) (Models.TimeBlock, error) {
+	if err := Models.CheckBookable(tx, therapist.ID, clinicGroupID, slot); err != nil {
+		return Models.TimeBlock{}, err
+	}
+	if err := Models.CheckBranchHours(tx, therapist.BranchID, slot); err != nil {
+		return Models.TimeBlock{}, err
+	}
+	if err := Models.AllocateResources(tx, clinicGroupID, therapist.BranchID, &slot, requirement); err != nil {
+		return Models.TimeBlock{}, err
+	}
+	return Models.BookTimeBlock(tx, schedule, slot)
+}
+
+// bookingError maps the errors of bookSession and Models.JoinGroupSession to
+// a message shown with 409 Conflict, or returns "" for other errors.
+func bookingError(err error) string {
+	switch {
+	case errors.Is(err, Models.ErrTimeBlockTaken):
+		return "Time block already booked"
+	case errors.Is(err, Models.ErrGroupSessionFull):
+		return "Group session is full"
+	case errors.Is(err, Models.ErrNotGroupSession), errors.Is(err, Models.ErrGroupSessionEnded):
+		return "Group session not found"
+	}
+	if message := unavailabilityError(err); message != "" {
+		return message
+	}
+	return resourceError(err)
+}
@@ -0,0 +1,271 @@
+package Controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"PhysioUp/Models"
+	"PhysioUp/SSE"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkBranch responds with 404 and returns false when a branch is given
+// that isn't in the caller's clinic group.
+func checkBranch(c *gin.Context, branchID *uint) bool {
+	if branchID != nil && !clinicRecordExists(c, &Models.Branch{}, *branchID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return false
+	}
+	return true
+}
+
+// branchQuery reads the optional branch_id query parameter, see checkBranch.
+func branchQuery(c *gin.Context) (*uint, bool) {
+	value := c.Query("branch_id")
+	if value == "" {
+		return nil, true
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid branch_id"})
+		return nil, false
+	}
+	branchID := uint(id)
+	return &branchID, checkBranch(c, &branchID)
+}
+
+func FetchBranches(c *gin.Context) {
+	var branches []Models.Branch
+	if err := getScopedDB(c).Model(&Models.Branch{}).Order("name").Find(&branches).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, branches)
+}
+
+func AddBranch(c *gin.Context) {
+	var input Models.Branch
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.ID = 0
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Branch needs a name"})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := getScopedDB(c).Create(&input).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add branch"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Branch Added Successfully", "id": input.ID})
+}
+
+// EditBranch updates a branch. New opening hours only apply to bookings made
+// from now on.
+func EditBranch(c *gin.Context) {
+	var input Models.Branch
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Branch needs a name"})
+		return
+	}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := getScopedDB(c).Model(&Models.Branch{}).Where("id = ?", input.ID).Updates(map[string]interface{}{
+		"name":      input.Name,
+		"address":   input.Address,
+		"phone":     input.Phone,
+		"opens_at":  input.OpensAt,
+		"closes_at": input.ClosesAt,
+	})
+	if result.Error != nil {
+		log.Println(result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update branch"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Branch Updated Successfully"})
+}
+
+// RemoveBranch deletes a branch. Its therapists, rooms and equipment are
+// left without a branch, while past appointments keep it for reporting.
+func RemoveBranch(c *gin.Context) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := getScopedDB(c).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result := tx.Delete(&Models.Branch{}, input.ID)
+	if result.Error != nil {
+		log.Println(result.Error)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove branch"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Branch not found"})
+		return
+	}
+
+	for _, model := range []interface{}{&Models.Therapist{}, &Models.Room{}, &Models.Equipment{}} {
+		if err := tx.Model(model).Where("branch_id = ?", input.ID).Update("branch_id", nil).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove branch"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Branch Removed Successfully"})
+}
+
+// AssignTherapistBranch moves a therapist to a branch, or off every branch
+// when branch_id is null. Booked appointments stay in their branch.
+func AssignTherapistBranch(c *gin.Context) {
+	var input struct {
+		TherapistID uint  `json:"therapist_id" binding:"required"`
+		BranchID    *uint `json:"branch_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkBranch(c, input.BranchID) {
+		return
+	}
+
+	result := getScopedDB(c).Model(&Models.Therapist{}).Where("id = ?", input.TherapistID).Update("branch_id", input.BranchID)
+	if result.Error != nil {
+		log.Println(result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update therapist"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Therapist Updated Successfully"})
+}
+
+// GetBranchReport totals the appointments of every branch between date_from
+// and date_to, both inclusive and defaulting to the current month.
+// Appointments booked with therapists outside any branch are reported under
+// a null branch_id.
+func GetBranchReport(c *gin.Context) {
+	branchID, ok := branchQuery(c)
+	if !ok {
+		return
+	}
+
+	location := clinicLocation(c)
+	today := Models.Today(location)
+	from := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, location)
+	to := from.AddDate(0, 1, -1)
+	var err error
+	if value := c.Query("date_from"); value != "" {
+		if from, err = time.ParseInLocation("2006-01-02", value, location); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date_from format. Use YYYY-MM-DD"})
+			return
+		}
+	}
+	if value := c.Query("date_to"); value != "" {
+		if to, err = time.ParseInLocation("2006-01-02", value, location); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date_to format. Use YYYY-MM-DD"})
+			return
+		}
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start date must be before end date"})
+		return
+	}
+
+	type branchTotals struct {
+		BranchID     *uint   `json:"branch_id"`
+		BranchName   string  `json:"branch_name"`
+		Appointments int64   `json:"appointments"`
+		Completed    int64   `json:"completed"`
+		Revenue      float64 `json:"revenue"`
+		Paid         float64 `json:"paid"`
+	}
+
+	var totals []branchTotals
+	query := getScopedDB(c).Model(&Models.Appointment{}).
+		Select("branch_id, COUNT(*) AS appointments, "+
+			"COUNT(*) FILTER (WHERE is_completed) AS completed, "+
+			"COALESCE(SUM(price), 0) AS revenue, "+
+			"COALESCE(SUM(price) FILTER (WHERE is_paid), 0) AS paid").
+		Where("date_time >= ? AND date_time < ?", from, to.AddDate(0, 0, 1)).
+		Group("branch_id").
+		Order("branch_id")
+	if branchID != nil {
+		query = query.Where("branch_id = ?", *branchID)
+	}
+	if err := query.Scan(&totals).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	// Removed branches are still named in the report
+	var branches []Models.Branch
+	if err := getScopedDB(c).Unscoped().Model(&Models.Branch{}).Select("id", "name").Find(&branches).Error; err != nil {
+		log.Println(err)
+	}
+	names := make(map[uint]string, len(branches))
+	for _, branch := range branches {
+		names[branch.ID] = branch.Name
+	}
+	for index := range totals {
+		if totals[index].BranchID != nil {
+			totals[index].BranchName = names[*totals[index].BranchID]
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date_from": from.Format("2006-01-02"),
+		"date_to":   to.Format("2006-01-02"),
+		"branches":  totals,
+	})
+}
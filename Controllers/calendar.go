@@ -0,0 +1,245 @@
+package Controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/ICal"
+	"PhysioUp/Utils/Token"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How far back and ahead the calendar feed reaches
+const (
+	calendarFeedPastDays   = 60
+	calendarFeedFutureDays = 365
+)
+
+// calendarTherapist resolves the therapist a feed request is about: the given
+// one if the caller manages the clinic and it belongs to their clinic group,
+// otherwise the caller.
+func calendarTherapist(c *gin.Context, therapistID uint) (Models.Therapist, error) {
+	role, _ := c.Get("role")
+	if userRole, ok := role.(Models.Role); ok && userRole.Can(Models.PermissionClinic) && therapistID != 0 {
+		clinicGroupID, _ := c.Get("clinicGroupID")
+		id, _ := clinicGroupID.(uint)
+		return findClinicTherapist(therapistID, id)
+	}
+
+	var therapist Models.Therapist
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		return therapist, err
+	}
+	err = Models.DB.Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error
+	return therapist, err
+}
+
+func calendarFeedURL(c *gin.Context, therapist Models.Therapist) string {
+	scheme := "https"
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if c.Request.TLS == nil {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/api/calendar/%d.ics?token=%s", scheme, c.Request.Host, therapist.ID, Token.CalendarFeedToken(therapist.ID, therapist.CalendarSecret))
+}
+
+func rotateCalendarSecret(therapist *Models.Therapist) error {
+	secret, _, err := Models.GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+	if err := Models.DB.Model(therapist).Update("calendar_secret", secret).Error; err != nil {
+		return err
+	}
+	therapist.CalendarSecret = secret
+	return nil
+}
+
+func GetCalendarFeedURL(c *gin.Context) {
+	therapistID, _ := strconv.ParseUint(c.Query("therapist_id"), 10, 64)
+	therapist, err := calendarTherapist(c, uint(therapistID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	if therapist.CalendarSecret == "" {
+		if err := rotateCalendarSecret(&therapist); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": calendarFeedURL(c, therapist)})
+}
+
+// RotateCalendarFeed replaces the therapist's feed secret so previously shared
+// subscription URLs stop working.
+func RotateCalendarFeed(c *gin.Context) {
+	var input struct {
+		TherapistID uint `json:"therapist_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	therapist, err := calendarTherapist(c, input.TherapistID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	if err := rotateCalendarSecret(&therapist); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate calendar feed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar Feed Rotated Successfully", "url": calendarFeedURL(c, therapist)})
+}
+
+// TherapistCalendarFeed serves the therapist's schedule as an iCalendar
+// subscription. Deleted blocks are kept as cancelled events for a while so
+// subscribed calendars drop them.
+func TherapistCalendarFeed(c *gin.Context) {
+	therapistID, err := strconv.ParseUint(strings.TrimSuffix(c.Param("therapist_id"), ".ics"), 10, 64)
+	if err != nil {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+
+	var therapist Models.Therapist
+	if err := Models.DB.Model(&Models.Therapist{}).Where("id = ?", therapistID).Preload("Schedule").First(&therapist).Error; err != nil ||
+		!Token.CalendarFeedTokenValid(therapist.ID, therapist.CalendarSecret, c.Query("token")) {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+
+	now := time.Now()
+	from := now.AddDate(0, 0, -calendarFeedPastDays)
+	to := now.AddDate(0, 0, calendarFeedFutureDays)
+
+	var timeBlocks []Models.TimeBlock
+	if err := Models.DB.Unscoped().Model(&Models.TimeBlock{}).
+		Where("schedule_id = ? AND date_time >= ? AND date_time < ?", therapist.Schedule.ID, from, to).
+		Where("is_available = ?", false).
+		Where("(deleted_at IS NULL OR deleted_at > ?)", from).
+		Order("date_time").
+		Find(&timeBlocks).Error; err != nil {
+		log.Println(err)
+		c.String(http.StatusInternalServerError, "Failed to load schedule")
+		return
+	}
+
+	var timeBlockIDs []uint
+	for _, timeBlock := range timeBlocks {
+		timeBlockIDs = append(timeBlockIDs, timeBlock.ID)
+	}
+
+	var appointments []Models.Appointment
+	if len(timeBlockIDs) > 0 {
+		if err := Models.DB.Unscoped().Model(&Models.Appointment{}).Where("time_block_id IN ?", timeBlockIDs).Find(&appointments).Error; err != nil {
+			log.Println(err)
+			c.String(http.StatusInternalServerError, "Failed to load schedule")
+			return
+		}
+	}
+
+	var treatmentPlanIDs []uint
+	appointmentsByBlock := map[uint][]Models.Appointment{}
+	for _, appointment := range appointments {
+		if appointment.TreatmentPlanID != nil {
+			treatmentPlanIDs = append(treatmentPlanIDs, *appointment.TreatmentPlanID)
+		}
+		// Attendees removed from a group session are left out
+		if !appointment.DeletedAt.Valid {
+			appointmentsByBlock[appointment.TimeBlockID] = append(appointmentsByBlock[appointment.TimeBlockID], appointment)
+		}
+	}
+
+	packages := map[uint]string{}
+	if len(treatmentPlanIDs) > 0 {
+		var rows []struct {
+			ID          uint
+			Description string
+		}
+		if err := Models.DB.Table("treatment_plans").
+			Select("treatment_plans.id, super_treatment_plans.description").
+			Joins("JOIN super_treatment_plans ON super_treatment_plans.id = treatment_plans.super_treatment_plan_id").
+			Where("treatment_plans.id IN ?", treatmentPlanIDs).
+			Scan(&rows).Error; err != nil {
+			log.Println(err)
+		}
+		for _, row := range rows {
+			packages[row.ID] = row.Description
+		}
+	}
+
+	var events []ICal.Event
+	for _, timeBlock := range timeBlocks {
+		event := ICal.Event{
+			UID:          fmt.Sprintf("timeblock-%d@physioup", timeBlock.ID),
+			Start:        timeBlock.DateTime.Time,
+			End:          timeBlock.End(),
+			Status:       ICal.StatusConfirmed,
+			LastModified: timeBlock.UpdatedAt,
+		}
+
+		var lines []string
+		attendees := appointmentsByBlock[timeBlock.ID]
+		if timeBlock.DeletedAt.Valid {
+			// Deleting a block deletes its appointments too, so use them all
+			attendees = nil
+			for _, appointment := range appointments {
+				if appointment.TimeBlockID == timeBlock.ID {
+					attendees = append(attendees, appointment)
+				}
+			}
+		}
+		for _, appointment := range attendees {
+			if appointment.UpdatedAt.After(event.LastModified) {
+				event.LastModified = appointment.UpdatedAt
+			}
+			line := appointment.PatientName
+			if appointment.TreatmentPlanID != nil && packages[*appointment.TreatmentPlanID] != "" {
+				line += " - " + packages[*appointment.TreatmentPlanID]
+			}
+			if appointment.Notes != "" {
+				line += ": " + appointment.Notes
+			}
+			lines = append(lines, line)
+		}
+
+		switch {
+		case timeBlock.IsGroup:
+			event.Summary = fmt.Sprintf("Group session (%d/%d)", len(attendees), timeBlock.Capacity)
+		case len(attendees) > 0:
+			event.Summary = "Session: " + attendees[0].PatientName
+		default:
+			event.Summary = "Blocked"
+		}
+		event.Description = strings.Join(lines, "\n")
+
+		if timeBlock.DeletedAt.Valid {
+			event.Status = ICal.StatusCancelled
+			event.LastModified = timeBlock.DeletedAt.Time
+		}
+		event.Sequence = event.LastModified.Unix()
+
+		events = append(events, event)
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=therapist-%d.ics", therapist.ID))
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(ICal.Render(therapist.Name, events)))
+}
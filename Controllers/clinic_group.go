@@ -0,0 +1,107 @@
+package Controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"PhysioUp/Models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetClinicGroup(c *gin.Context) {
+	clinicGroupID, _ := c.Get("clinicGroupID")
+
+	var group Models.ClinicGroup
+	if err := getScopedDB(c).First(&group, clinicGroupID).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Clinic group not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+func UpdateClinicGroupSettings(c *gin.Context) {
+	var input struct {
+		TimeZone                *string `json:"time_zone"`
+		CancellationCutoffHours *uint   `json:"cancellation_cutoff_hours"`
+		Slug                    *string `json:"slug"`
+		RequireTOTP             *bool   `json:"require_totp"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.TimeZone != nil {
+		if _, err := time.LoadLocation(*input.TimeZone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone, use an IANA name such as Africa/Cairo"})
+			return
+		}
+		updates["time_zone"] = *input.TimeZone
+	}
+	if input.CancellationCutoffHours != nil {
+		if *input.CancellationCutoffHours > 24*14 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cancellation cutoff can't be longer than 14 days"})
+			return
+		}
+		updates["cancellation_cutoff_hours"] = *input.CancellationCutoffHours
+	}
+	if input.Slug != nil {
+		if !Models.ValidSlug(*input.Slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Slug must be 3 to 63 lowercase letters, digits or single hyphens"})
+			return
+		}
+		updates["slug"] = *input.Slug
+	}
+	if input.RequireTOTP != nil {
+		updates["require_totp"] = *input.RequireTOTP
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+
+	if err := getScopedDB(c).Model(&Models.ClinicGroup{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Slug already taken"})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update clinic group"})
+		return
+	}
+	Models.InvalidateClinicGroupLocation(id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Clinic Group Updated Successfully"})
+}
+
+// GetPublicClinicGroup returns what the public booking page shows about the
+// clinic group of its slug.
+func GetPublicClinicGroup(c *gin.Context) {
+	clinicGroupID, ok := publicClinicGroupID(c)
+	if !ok {
+		return
+	}
+
+	var group Models.ClinicGroup
+	if err := Models.DB.First(&group, clinicGroupID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Clinic not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":                      group.Name,
+		"slug":                      group.Slug,
+		"time_zone":                 group.Location().String(),
+		"cancellation_cutoff_hours": group.CancellationCutoffHours,
+	})
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/360EntSecGroup-Skylar/excelize"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func DaysBetweenDates(Date1, Date2 string) int {
@@ -24,18 +25,28 @@ func ExportSalesTable(c *gin.Context) {
 	var input struct {
 		DateFrom string `json:"date_from"`
 		DateTo   string `json:"date_to"`
+		BranchID *uint  `json:"branch_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, err)
 		return
 	}
+	if !checkBranch(c, input.BranchID) {
+		return
+	}
+
+	// An open ended range runs up to today in the clinic's time zone
+	if input.DateFrom != "" && input.DateTo == "" {
+		input.DateTo = Models.Today(clinicLocation(c)).Format("2006-01-02")
+	}
 
 	var TreatmentPlans []Models.TreatmentPlan
 
 	if input.DateFrom != "" && input.DateTo != "" {
 		// Days := DaysBetweenDates(input.DateFrom, input.DateTo)
-		if err := Models.DB.Model(&Models.TreatmentPlan{}).
+		if err := getScopedDB(c).Model(&Models.TreatmentPlan{}).
+			Scopes(packagesInBranch(input.BranchID)).
 			Where("date BETWEEN ? AND ?", input.DateFrom, input.DateTo).
 			Find(&TreatmentPlans).Error; err != nil {
 			c.JSON(http.StatusBadRequest, err)
@@ -43,7 +54,7 @@ func ExportSalesTable(c *gin.Context) {
 		}
 
 	} else {
-		if err := Models.DB.Model(&Models.TreatmentPlan{}).Find(&TreatmentPlans).Error; err != nil {
+		if err := getScopedDB(c).Model(&Models.TreatmentPlan{}).Scopes(packagesInBranch(input.BranchID)).Find(&TreatmentPlans).Error; err != nil {
 			c.JSON(http.StatusBadRequest, err)
 			return
 		}
@@ -51,7 +62,7 @@ func ExportSalesTable(c *gin.Context) {
 
 	for index := range TreatmentPlans {
 		if TreatmentPlans[index].ReferralID != nil {
-			Models.DB.Model(&Models.Referral{}).Where("id = ?", TreatmentPlans[index].ReferralID).First(&TreatmentPlans[index].Referral)
+			getScopedDB(c).Model(&Models.Referral{}).Where("id = ?", TreatmentPlans[index].ReferralID).First(&TreatmentPlans[index].Referral)
 		}
 	}
 
@@ -73,13 +84,19 @@ func ExportSalesTable(c *gin.Context) {
 	for i := 0; i < len(TreatmentPlans); i++ {
 		appendRowSales(sheet, file, i, TreatmentPlans)
 	}
-	var filename string = fmt.Sprintf("./Sales.xlsx")
-	if err := file.SaveAs(filename); err != nil {
-		log.Println(err)
+	writeExcel(c, file, "Sales.xlsx")
+}
+
+// Packages are shared by the clinic group, so a package counts towards every
+// branch it has sessions booked in
+func packagesInBranch(branchID *uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if branchID == nil {
+			return db
+		}
+		return db.Where("treatment_plans.id IN (?)",
+			db.Session(&gorm.Session{NewDB: true}).Model(&Models.Appointment{}).Select("treatment_plan_id").Where("branch_id = ?", *branchID))
 	}
-	// c.Context().SetContentType("multipart/form-data")
-	// return c.Response().SendFile("./tasks.xlsx")
-	c.File(filename)
 }
 
 func appendRowSales(sheet string, file *excelize.File, index int, rows []Models.TreatmentPlan) (fileWriter *excelize.File) {
@@ -95,28 +112,32 @@ func appendRowSales(sheet string, file *excelize.File, index int, rows []Models.
 
 func ExportReferredPackagesExcel(c *gin.Context) {
 	var input struct {
-		ReferralID uint `json:"referral_id"`
+		ReferralID uint  `json:"referral_id"`
+		BranchID   *uint `json:"branch_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !checkBranch(c, input.BranchID) {
+		return
+	}
 
 	var Referral Models.Referral
 
-	if err := Models.DB.Model(&Models.Referral{}).Where("id = ?", input.ReferralID).Find(&Referral).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := getScopedDB(c).Model(&Models.Referral{}).Where("id = ?", input.ReferralID).First(&Referral).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Referral not found"})
 		return
 	}
 
 	var TreatmentPlans []Models.TreatmentPlan
-	if err := Models.DB.Model(&Models.TreatmentPlan{}).Where("referral_id = ?", input.ReferralID).Find(&TreatmentPlans).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.TreatmentPlan{}).Scopes(packagesInBranch(input.BranchID)).Where("referral_id = ?", input.ReferralID).Find(&TreatmentPlans).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	for index := range TreatmentPlans {
-		if err := Models.DB.Model(&Models.SuperTreatmentPlan{}).Where("id = ?", TreatmentPlans[index].SuperTreatmentPlanID).Find(&TreatmentPlans[index].SuperTreatmentPlan).Error; err != nil {
+		if err := getScopedDB(c).Model(&Models.SuperTreatmentPlan{}).Where("id = ?", TreatmentPlans[index].SuperTreatmentPlanID).Find(&TreatmentPlans[index].SuperTreatmentPlan).Error; err != nil {
 			c.JSON(http.StatusOK, nil)
 			return
 		}
@@ -140,13 +161,7 @@ func ExportReferredPackagesExcel(c *gin.Context) {
 	for i := 0; i < len(TreatmentPlans); i++ {
 		appendRowReferral(sheet, file, i, TreatmentPlans, Referral.CashbackPercentage)
 	}
-	var filename string = fmt.Sprintf("./Referrals.xlsx")
-	if err := file.SaveAs(filename); err != nil {
-		log.Println(err)
-	}
-	// c.Context().SetContentType("multipart/form-data")
-	// return c.Response().SendFile("./tasks.xlsx")
-	c.File(filename)
+	writeExcel(c, file, "Referrals.xlsx")
 
 }
 
@@ -159,3 +174,14 @@ func appendRowReferral(sheet string, file *excelize.File, index int, rows []Mode
 	return file
 
 }
+
+// Exports are streamed rather than saved to disk, where concurrent exports
+// from different clinics would overwrite each other
+func writeExcel(c *gin.Context, file *excelize.File, filename string) {
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+	if err := file.Write(c.Writer); err != nil {
+		log.Println(err)
+	}
+}
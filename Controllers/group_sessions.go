@@ -0,0 +1,102 @@
+package Controllers
+
+import (
+	"log"
+	"net/http"
+
+	"PhysioUp/Models"
+	"PhysioUp/SSE"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateGroupSession books a slot on the therapist's schedule that several
+// patients can join, up to its capacity.
+func CreateGroupSession(c *gin.Context) {
+	var input struct {
+		TherapistID          uint            `json:"therapist_id" binding:"required"`
+		DateTime             Models.DateTime `json:"date_time"`
+		DurationMinutes      uint            `json:"duration_minutes"`
+		Capacity             uint            `json:"capacity" binding:"required"`
+		SuperTreatmentPlanID uint            `json:"super_treatment_plan_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+	if input.DateTime.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date time is required"})
+		return
+	}
+	if input.Capacity < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Group sessions need a capacity of at least 2"})
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+
+	therapist, err := findClinicTherapist(input.TherapistID, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	tx := getScopedDB(c).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	schedule, err := findOrCreateSchedule(tx, therapist.ID)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load therapist schedule"})
+		return
+	}
+
+	var plan Models.SuperTreatmentPlan
+	if input.SuperTreatmentPlanID != 0 {
+		if err := tx.Model(&Models.SuperTreatmentPlan{}).Where("id = ? AND clinic_group_id = ?", input.SuperTreatmentPlanID, id).First(&plan).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Super treatment plan not found"})
+			return
+		}
+	}
+	if input.DurationMinutes == 0 {
+		input.DurationMinutes = plan.SessionMinutes
+	}
+
+	dateTime := input.DateTime.WallClockIn(clinicLocation(c))
+	timeBlock, err := bookSession(tx, therapist, schedule, id, Models.NewSlot(therapist, dateTime, input.DurationMinutes), plan.Resources())
+	if err != nil {
+		tx.Rollback()
+		if message := bookingError(err); message != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group session"})
+		return
+	}
+
+	timeBlock.IsGroup = true
+	timeBlock.Capacity = input.Capacity
+	if err := tx.Model(&timeBlock).Select("is_group", "capacity").Updates(&timeBlock).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group session"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Group Session Created Successfully", "time_block": timeBlock})
+}
@@ -0,0 +1,95 @@
+package Controllers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"PhysioUp/Constants"
+	"PhysioUp/Models"
+	"PhysioUp/Utils/Token"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateInvite makes a single use invite for a role in the caller's clinic
+// group. The token is only returned here, it can't be fetched again.
+func CreateInvite(c *gin.Context) {
+	var input struct {
+		Role       Models.Role `json:"role" binding:"required"`
+		ValidHours uint        `json:"valid_hours"` // defaults to a week
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !input.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	ttl := Models.DefaultInviteTTL
+	if input.ValidHours != 0 {
+		ttl = time.Duration(input.ValidHours) * time.Hour
+	}
+	if ttl > Models.MaxInviteTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invites can't be valid for more than 30 days"})
+		return
+	}
+
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+
+	invite, token, err := Models.CreateInvite(getScopedDB(c), id, input.Role, user_id, ttl)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Invite Created Successfully",
+		"invite":  invite,
+		"token":   token,
+		"link":    Constants.PublicBookingURL + "/register?token=" + url.QueryEscape(token),
+	})
+}
+
+// FetchInvites lists the invites of the clinic group that can still be used.
+func FetchInvites(c *gin.Context) {
+	var invites []Models.Invite
+	if err := getScopedDB(c).Model(&Models.Invite{}).
+		Where("used_at IS NULL AND expires_at > ?", time.Now()).
+		Order("created_at DESC").Find(&invites).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, invites)
+}
+
+func RevokeInvite(c *gin.Context) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := getScopedDB(c).Where("used_at IS NULL").Delete(&Models.Invite{}, input.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invite Revoked Successfully"})
+}
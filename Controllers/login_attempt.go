@@ -0,0 +1,59 @@
+package Controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"PhysioUp/Models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// FetchLoginAttempts lists the login attempts on the clinic group's
+// accounts, newest first. It can be filtered by username, ip_address,
+// success and a date_from/date_to range, and is paged with limit and offset.
+func FetchLoginAttempts(c *gin.Context) {
+	limit, offset, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	query := getScopedDB(c).Model(&Models.LoginAttempt{})
+	if value := c.Query("username"); value != "" {
+		query = query.Where("username = ?", value)
+	}
+	if value := c.Query("ip_address"); value != "" {
+		query = query.Where("ip_address = ?", value)
+	}
+	if value := c.Query("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid success, use true or false"})
+			return
+		}
+		query = query.Where("success = ?", success)
+	}
+	if query, ok = createdBetween(c, query); !ok {
+		return
+	}
+
+	// Count and Find both build on the filters
+	query = query.Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load login attempts"})
+		return
+	}
+	attempts := []Models.LoginAttempt{}
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&attempts).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load login attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total": total, "attempts": attempts})
+}
@@ -0,0 +1,94 @@
+package Controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"PhysioUp/Models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tests that need a database run against the Postgres database in
+// TEST_DATABASE_URL and are skipped without one. It is migrated on start and
+// every test creates its own clinic groups, so it can be reused between runs,
+// but don't point it at real data.
+var testDatabase bool
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if dsn := os.Getenv("TEST_DATABASE_URL"); dsn != "" {
+		if err := Models.OpenDataBase(dsn); err != nil {
+			log.Fatal("connection error:", err)
+		}
+		Models.MigrateDataBase()
+		testDatabase = true
+	}
+	os.Exit(m.Run())
+}
+
+func requireDatabase(t *testing.T) {
+	t.Helper()
+	if !testDatabase {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+}
+
+var testSequence atomic.Int64
+
+// uniqueName returns prefix followed by a number no other test run uses.
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s %d-%d", prefix, time.Now().UnixNano(), testSequence.Add(1))
+}
+
+func mustCreate(t *testing.T, value interface{}) {
+	t.Helper()
+	if err := Models.DB.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+func newClinicGroup(t *testing.T) Models.ClinicGroup {
+	t.Helper()
+	group := Models.ClinicGroup{Name: uniqueName("Clinic")}
+	mustCreate(t, &group)
+	return group
+}
+
+// newTherapist creates a therapist of the clinic group with an empty schedule.
+func newTherapist(t *testing.T, clinicGroupID uint) (Models.Therapist, Models.Schedule) {
+	t.Helper()
+	therapist := Models.Therapist{Name: uniqueName("Therapist"), ClinicGroupID: clinicGroupID, SessionMinutes: 60}
+	mustCreate(t, &therapist)
+	schedule := Models.Schedule{TherapistID: therapist.ID}
+	mustCreate(t, &schedule)
+	return therapist, schedule
+}
+
+func newPatient(t *testing.T, clinicGroupID uint) Models.Patient {
+	t.Helper()
+	patient := Models.Patient{Name: uniqueName("Patient"), Phone: "201000000000", ClinicGroupID: clinicGroupID}
+	mustCreate(t, &patient)
+	return patient
+}
+
+// callHandler runs handler on a POST of body as JSON, made by a staff member
+// of the clinic group.
+func callHandler(handler gin.HandlerFunc, clinicGroupID uint, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("clinicGroupID", clinicGroupID)
+	handler(c)
+	return recorder
+}
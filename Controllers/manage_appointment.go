@@ -0,0 +1,331 @@
+package Controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"PhysioUp/Constants"
+	"PhysioUp/FirebaseMessaging"
+	"PhysioUp/Models"
+	"PhysioUp/SSE"
+	"PhysioUp/Utils/Token"
+	"PhysioUp/Whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// How far ahead patients can move their appointment through the link
+const manageAppointmentDays = 14
+
+// appointmentManageLink returns the link sent to the patient to cancel or
+// reschedule the appointment themselves. It expires at the appointment time.
+func appointmentManageLink(appointment Models.Appointment) string {
+	token, err := Token.GenerateAppointmentToken(appointment.ID, appointment.DateTime.Time)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	return fmt.Sprintf("%s/appointment/manage?token=%s", Constants.PublicBookingURL, url.QueryEscape(token))
+}
+
+// managedAppointment loads the appointment a link token was issued for.
+func managedAppointment(db *gorm.DB, token string) (Models.Appointment, error) {
+	var appointment Models.Appointment
+	appointmentID, err := Token.ParseAppointmentToken(token)
+	if err != nil {
+		return appointment, err
+	}
+	err = db.Model(&Models.Appointment{}).Where("id = ?", appointmentID).First(&appointment).Error
+	return appointment, err
+}
+
+// cancellationDeadline is the last moment the patient can still change the
+// appointment through the link.
+func cancellationDeadline(appointment Models.Appointment) time.Time {
+	var group Models.ClinicGroup
+	cutoffHours := uint(24)
+	if err := Models.DB.Select("id", "cancellation_cutoff_hours").First(&group, appointment.ClinicGroupID).Error; err == nil {
+		cutoffHours = group.CancellationCutoffHours
+	}
+	return appointment.DateTime.Add(-time.Duration(cutoffHours) * time.Hour)
+}
+
+// freeTimeBlocks lists the therapist's open slots between from and to that
+// aren't taken by a booked session, a leave or a closure.
+func freeTimeBlocks(therapistID uint, clinicGroupID uint, from time.Time, to time.Time) ([]Models.TimeBlock, error) {
+	var timeBlocks []Models.TimeBlock
+	if err := Models.DB.Model(&Models.TimeBlock{}).
+		Joins("JOIN schedules ON schedules.id = time_blocks.schedule_id").
+		Where("schedules.therapist_id = ? AND time_blocks.date_time >= ? AND time_blocks.date_time < ?", therapistID, from, to).
+		Order("time_blocks.date_time").
+		Find(&timeBlocks).Error; err != nil {
+		return nil, err
+	}
+
+	var closures []Models.ClinicClosure
+	if err := Models.DB.Where("clinic_group_id = ? AND ends_at > ? AND starts_at < ?", clinicGroupID, from, to).Find(&closures).Error; err != nil {
+		return nil, err
+	}
+	var leaves []Models.TherapistLeave
+	if err := Models.DB.Where("therapist_id = ? AND ends_at > ? AND starts_at < ?", therapistID, from, to).Find(&leaves).Error; err != nil {
+		return nil, err
+	}
+
+	free := []Models.TimeBlock{}
+	for _, open := range timeBlocks {
+		if !open.IsAvailable || open.IsGroup {
+			continue
+		}
+		taken := false
+		for _, closure := range closures {
+			taken = taken || closure.Overlaps(open.DateTime.Time, open.BusyUntil())
+		}
+		for _, leave := range leaves {
+			taken = taken || leave.Overlaps(open.DateTime.Time, open.BusyUntil())
+		}
+		for _, booked := range timeBlocks {
+			taken = taken || (!booked.IsAvailable && booked.DateTime.Before(open.End()) && booked.BusyUntil().After(open.DateTime.Time))
+		}
+		if !taken {
+			free = append(free, open)
+		}
+	}
+	return free, nil
+}
+
+// GetManagedAppointment shows the patient their appointment and the free
+// slots of the same therapist they can move it to.
+func GetManagedAppointment(c *gin.Context) {
+	appointment, err := managedAppointment(Models.DB, c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found or link expired"})
+		return
+	}
+
+	var timeBlock Models.TimeBlock
+	if err := Models.DB.Select("id", "is_group").First(&timeBlock, appointment.TimeBlockID).Error; err != nil {
+		log.Println(err)
+	}
+
+	deadline := cancellationDeadline(appointment)
+	canModify := !appointment.IsCompleted && time.Now().Before(deadline)
+
+	type TimeBlockDTO struct {
+		ID              uint            `json:"ID"`
+		DateTime        Models.DateTime `json:"date"`
+		DurationMinutes uint            `json:"duration_minutes"`
+	}
+	timeBlockDTOs := []TimeBlockDTO{}
+	if canModify {
+		now := time.Now()
+		// The new slot must also be outside the cutoff window
+		from := now.Add(appointment.DateTime.Sub(deadline))
+		timeBlocks, err := freeTimeBlocks(appointment.TherapistID, appointment.ClinicGroupID, from, now.AddDate(0, 0, manageAppointmentDays))
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load free time blocks"})
+			return
+		}
+		for _, block := range timeBlocks {
+			timeBlockDTOs = append(timeBlockDTOs, TimeBlockDTO{ID: block.ID, DateTime: block.DateTime, DurationMinutes: block.DurationMinutes})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"appointment": gin.H{
+			"ID":             appointment.ID,
+			"date_time":      appointment.DateTime,
+			"therapist_name": appointment.TherapistName,
+			"patient_name":   appointment.PatientName,
+			"is_group":       timeBlock.IsGroup,
+		},
+		"can_modify":       canModify,
+		"modify_deadline":  Models.DateTime{Time: deadline.In(Models.ClinicGroupLocation(appointment.ClinicGroupID))},
+		"free_time_blocks": timeBlockDTOs,
+	})
+}
+
+// CancelManagedAppointment lets the patient cancel their appointment through
+// the link in their confirmation message.
+func CancelManagedAppointment(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	appointment, err := managedAppointment(Models.DB, input.Token)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found or link expired"})
+		return
+	}
+
+	// Changes made through the link are audited without an actor
+	tx := auditedScopedDB(c, appointment.ClinicGroupID, 0).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Locked so a concurrent cancel or reschedule of the same appointment waits
+	appointment, err = managedAppointment(tx.Clauses(clause.Locking{Strength: "UPDATE"}), input.Token)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found or link expired"})
+		return
+	}
+	if appointment.IsCompleted || !time.Now().Before(cancellationDeadline(appointment)) {
+		tx.Rollback()
+		c.JSON(http.StatusForbidden, gin.H{"error": "It's too late to cancel this appointment, please contact the clinic"})
+		return
+	}
+
+	var timeBlock Models.TimeBlock
+	if err := tx.First(&timeBlock, appointment.TimeBlockID).Error; err != nil {
+		log.Println(err)
+	}
+
+	// A group session stays for its other attendees
+	if timeBlock.ID != 0 && !timeBlock.IsGroup {
+		if err := tx.Delete(&timeBlock).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel appointment"})
+			return
+		}
+	}
+	if err := tx.Delete(&appointment).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel appointment"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment Cancelled Successfully"})
+	SSE.Broadcaster.Broadcast("refresh")
+	if timeBlock.ID != 0 && !timeBlock.IsGroup {
+		go offerFreedTimeBlock(timeBlock)
+	}
+
+	var therapist Models.Therapist
+	if err := Models.DB.Select("id", "user_id").First(&therapist, appointment.TherapistID).Error; err == nil {
+		fcms, _ := Models.GetGroupFCMsByID(therapist.UserID)
+		if len(fcms) > 0 {
+			go FirebaseMessaging.SendMessage(Models.NotificationRequest{Tokens: fcms, Title: "Appointment Cancelled By Patient", Body: fmt.Sprintf("%s cancelled their appointment at %s with %s", appointment.PatientName, appointment.DateTime, appointment.TherapistName)})
+		}
+	}
+
+	var patient Models.Patient
+	if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", appointment.PatientID).First(&patient).Error; err == nil && patient.Phone != "" {
+		go Whatsapp.SendMessage(patient.Phone, appointmentCancelledMessage(appointment.DateTime))
+	}
+}
+
+// RescheduleManagedAppointment lets the patient move their appointment to
+// another free slot of the same therapist through the link in their
+// confirmation message.
+func RescheduleManagedAppointment(c *gin.Context) {
+	var input struct {
+		Token       string `json:"token" binding:"required"`
+		TimeBlockID uint   `json:"time_block_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	appointment, err := managedAppointment(Models.DB, input.Token)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found or link expired"})
+		return
+	}
+
+	// Changes made through the link are audited without an actor
+	tx := auditedScopedDB(c, appointment.ClinicGroupID, 0).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Locked so a concurrent cancel or reschedule of the same appointment waits
+	appointment, err = managedAppointment(tx.Clauses(clause.Locking{Strength: "UPDATE"}), input.Token)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found or link expired"})
+		return
+	}
+	deadline := cancellationDeadline(appointment)
+	if appointment.IsCompleted || !time.Now().Before(deadline) {
+		tx.Rollback()
+		c.JSON(http.StatusForbidden, gin.H{"error": "It's too late to reschedule this appointment, please contact the clinic"})
+		return
+	}
+
+	var therapist Models.Therapist
+	if err := tx.Model(&Models.Therapist{}).Where("id = ?", appointment.TherapistID).Preload("Schedule").First(&therapist).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	var timeBlock Models.TimeBlock
+	if err := tx.Where("id = ? AND schedule_id = ? AND is_available = ? AND is_group = ?", input.TimeBlockID, therapist.Schedule.ID, true, false).First(&timeBlock).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Time block not found"})
+		return
+	}
+	now := time.Now()
+	if timeBlock.DateTime.Before(now.Add(appointment.DateTime.Sub(deadline))) || timeBlock.DateTime.After(now.AddDate(0, 0, manageAppointmentDays)) {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Time block is outside the allowed booking window"})
+		return
+	}
+
+	previousDateTime := appointment.DateTime
+	if err := moveAppointment(tx, &appointment, therapist, timeBlock.DateTime); err != nil {
+		tx.Rollback()
+		if message := bookingError(err); message != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reschedule appointment"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment Rescheduled Successfully", "date_time": appointment.DateTime})
+	SSE.Broadcaster.Broadcast("refresh")
+	go OfferFreedSlot(therapist.ID, previousDateTime)
+
+	fcms, _ := Models.GetGroupFCMsByID(therapist.UserID)
+	if len(fcms) > 0 {
+		go FirebaseMessaging.SendMessage(Models.NotificationRequest{Tokens: fcms, Title: "Appointment Rescheduled By Patient", Body: fmt.Sprintf("%s moved their appointment to %s with %s", appointment.PatientName, appointment.DateTime, appointment.TherapistName)})
+	}
+
+	var patient Models.Patient
+	if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", appointment.PatientID).First(&patient).Error; err == nil && patient.Phone != "" {
+		go Whatsapp.SendMessage(patient.Phone, appointmentRescheduledMessage(appointment.DateTime, appointment.TherapistName, appointmentManageLink(appointment)))
+	}
+}
@@ -0,0 +1,195 @@
+package Controllers
+
+import (
+	"PhysioUp/Constants"
+	"PhysioUp/Models"
+	"fmt"
+	"strings"
+)
+
+var arabicDigits = []string{
+	"0", "٠",
+	"1", "١",
+	"2", "٢",
+	"3", "٣",
+	"4", "٤",
+	"5", "٥",
+	"6", "٦",
+	"7", "٧",
+	"8", "٨",
+	"9", "٩",
+}
+
+// Convert dates to Arabic format (replace Western numbers with Arabic numbers)
+var arabicDateReplacer = strings.NewReplacer(arabicDigits...)
+
+// Convert times to Arabic format
+var arabicTimeReplacer = strings.NewReplacer(append(arabicDigits, "AM", "صباحًا", "PM", "مساءً")...)
+
+// messageDateTime holds an appointment time formatted for the bilingual
+// WhatsApp messages.
+type messageDateTime struct {
+	Date       string
+	Time       string
+	ArabicDate string
+	ArabicTime string
+}
+
+func formatMessageDateTime(dateTime Models.DateTime) messageDateTime {
+	date := dateTime.Format("02/01/2006")
+	time := dateTime.Format("3:04 PM")
+	return messageDateTime{
+		Date:       date,
+		Time:       time,
+		ArabicDate: arabicDateReplacer.Replace(date),
+		ArabicTime: arabicTimeReplacer.Replace(time),
+	}
+}
+
+// Remove "Dr." prefix if it exists
+func trimDoctorPrefix(name string) string {
+	name = strings.TrimPrefix(name, "Dr. ")
+	name = strings.TrimPrefix(name, "د. ")
+	name = strings.TrimPrefix(name, "Dr.")
+	name = strings.TrimPrefix(name, "د.")
+	return name
+}
+
+// manageAppointmentLines tells the patient where to cancel or reschedule, or
+// is empty when there is no link.
+func manageAppointmentLines(link string) string {
+	if link == "" {
+		return ""
+	}
+	return fmt.Sprintf("To cancel or reschedule: %s\\n"+
+		"لإلغاء الموعد أو تغييره: %s\\n\\n", link, link)
+}
+
+func appointmentConfirmationMessage(dateTime Models.DateTime, therapistName string, manageLink string) string {
+	formatted := formatMessageDateTime(dateTime)
+	therapistName = trimDoctorPrefix(therapistName)
+	return fmt.Sprintf("🗓️ *APPOINTMENT CONFIRMATION* 🗓️\\n\\n"+
+		"Dear Patient,\\n\\n"+
+		"Your appointment has been confirmed:\\n"+
+		"• *Date:* %s\\n"+
+		"• *Time:* %s\\n"+
+		"• *Therapist:* Dr. %s\\n\\n"+
+		"✅ *تأكيد الموعد* ✅\\n\\n"+
+		"عزيزي المريض،\\n\\n"+
+		"تم تأكيد موعدك:\\n"+
+		"• *التاريخ:* %s\\n"+
+		"• *الوقت:* %s\\n"+
+		"• *دكتور:* %s\\n\\n"+
+		"Please make sure to arrive on time.\\n"+
+		"يرجى التأكد من الوصول في الموعد المحدد.\\n\\n"+
+		"%s"+
+		"We look forward to seeing you! Thank you for choosing PhysioUP.\\n"+
+		"نتطلع لرؤيتك! شكراً لاختيارك PhysioUP.",
+		formatted.Date,
+		formatted.Time,
+		therapistName,
+		formatted.ArabicDate,
+		formatted.ArabicTime,
+		therapistName,
+		manageAppointmentLines(manageLink))
+}
+
+func appointmentRescheduledMessage(dateTime Models.DateTime, therapistName string, manageLink string) string {
+	formatted := formatMessageDateTime(dateTime)
+	therapistName = trimDoctorPrefix(therapistName)
+	return fmt.Sprintf("🔄 *APPOINTMENT RESCHEDULED* 🔄\\n\\n"+
+		"Dear Patient,\\n\\n"+
+		"Your appointment has been moved to:\\n"+
+		"• *Date:* %s\\n"+
+		"• *Time:* %s\\n"+
+		"• *Therapist:* Dr. %s\\n\\n"+
+		"🔄 *تم تغيير موعدك* 🔄\\n\\n"+
+		"عزيزي المريض،\\n\\n"+
+		"تم نقل موعدك إلى:\\n"+
+		"• *التاريخ:* %s\\n"+
+		"• *الوقت:* %s\\n"+
+		"• *دكتور:* %s\\n\\n"+
+		"Please make sure to arrive on time.\\n"+
+		"يرجى التأكد من الوصول في الموعد المحدد.\\n\\n"+
+		"%s"+
+		"Thank you for choosing PhysioUP.\\n"+
+		"شكراً لاختيارك PhysioUP.",
+		formatted.Date,
+		formatted.Time,
+		therapistName,
+		formatted.ArabicDate,
+		formatted.ArabicTime,
+		therapistName,
+		manageAppointmentLines(manageLink))
+}
+
+func waitlistOfferMessage(dateTime Models.DateTime, therapistName string, link string, minutes int) string {
+	formatted := formatMessageDateTime(dateTime)
+	therapistName = trimDoctorPrefix(therapistName)
+	return fmt.Sprintf("⏳ *A SLOT IS AVAILABLE* ⏳\\n\\n"+
+		"Dear Patient,\\n\\n"+
+		"A slot you are waiting for has become available:\\n"+
+		"• *Date:* %s\\n"+
+		"• *Time:* %s\\n"+
+		"• *Therapist:* Dr. %s\\n\\n"+
+		"Claim it within %d minutes: %s\\n\\n"+
+		"⏳ *موعد متاح* ⏳\\n\\n"+
+		"عزيزي المريض،\\n\\n"+
+		"أصبح موعد في قائمة انتظارك متاحًا:\\n"+
+		"• *التاريخ:* %s\\n"+
+		"• *الوقت:* %s\\n"+
+		"• *دكتور:* %s\\n\\n"+
+		"احجزه خلال %s دقيقة: %s",
+		formatted.Date,
+		formatted.Time,
+		therapistName,
+		minutes,
+		link,
+		formatted.ArabicDate,
+		formatted.ArabicTime,
+		therapistName,
+		arabicDateReplacer.Replace(fmt.Sprint(minutes)),
+		link)
+}
+
+func appointmentCancelledMessage(dateTime Models.DateTime) string {
+	formatted := formatMessageDateTime(dateTime)
+	return fmt.Sprintf("🚫 *APPOINTMENT CANCELLED* 🚫\\n\\n"+
+		"Dear Patient,\\n\\n"+
+		"Your appointment on %s at %s has been cancelled as you requested.\\n"+
+		"You can book a new one at %s\\n\\n"+
+		"🚫 *تم إلغاء الموعد* 🚫\\n\\n"+
+		"عزيزي المريض،\\n\\n"+
+		"تم إلغاء موعدك يوم %s الساعة %s بناءً على طلبك.\\n"+
+		"يمكنك حجز موعد جديد من خلال %s",
+		formatted.Date,
+		formatted.Time,
+		Constants.PublicBookingURL,
+		formatted.ArabicDate,
+		formatted.ArabicTime,
+		Constants.PublicBookingURL)
+}
+
+func otpMessage(code string) string {
+	minutes := int(Models.OTPTTL.Minutes())
+	return fmt.Sprintf("🔐 *PhysioUP verification code: %s*\\n\\n"+
+		"It expires in %d minutes. Don't share it with anyone.\\n\\n"+
+		"🔐 *رمز التحقق من PhysioUP: %s*\\n\\n"+
+		"ينتهي خلال %s دقائق. لا تشاركه مع أي شخص.",
+		code,
+		minutes,
+		code,
+		arabicDateReplacer.Replace(fmt.Sprint(minutes)))
+}
+
+func passwordResetMessage(code string) string {
+	minutes := int(Models.OTPTTL.Minutes())
+	return fmt.Sprintf("🔑 *PhysioUP password reset code: %s*\\n\\n"+
+		"It expires in %d minutes. If you didn't ask to reset your password, ignore this message.\\n\\n"+
+		"🔑 *رمز إعادة تعيين كلمة المرور في PhysioUP: %s*\\n\\n"+
+		"ينتهي خلال %s دقائق. إذا لم تطلب إعادة تعيين كلمة المرور، تجاهل هذه الرسالة.",
+		code,
+		minutes,
+		code,
+		arabicDateReplacer.Replace(fmt.Sprint(minutes)))
+}
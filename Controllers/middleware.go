@@ -2,34 +2,171 @@ package Controllers
 
 import (
 	"PhysioUp/Models"
-	"fmt"
+	"PhysioUp/Utils/Token"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// errNoClinicGroup fails the queries of a request that reached a scoped
+// handler without a clinic group.
+var errNoClinicGroup = errors.New("request has no clinic group")
+
+// getScopedDB returns a session limited to the caller's clinic group, see
+// Models.ScopedDB. A request without a clinic group is aborted with 500 and
+// gets a session whose every query fails, so it never reaches other clinics'
+// data.
 func getScopedDB(c *gin.Context) *gorm.DB {
-	db, exists := c.Get("db")
-	if !exists {
-		fmt.Println("dne")
-		return Models.DB // Return the default DB if scoped DB doesn't exist
-	}
-
-	// Check if db is a function that requires a table name
-	dbFunc, ok := db.(func(string) *gorm.DB)
-	if ok {
-		// Use the model name from the controller context
-		// This would need to be set in each controller
-		modelName, exists := c.Get("modelName")
-		if exists {
-			tableName, ok := modelName.(string)
-			if ok {
-				return dbFunc(tableName)
-			}
+	clinicGroupID, exists := c.Get("clinicGroupID")
+	id, ok := clinicGroupID.(uint)
+	if !exists || !ok {
+		log.Printf("%s %s has no clinic group, refusing to query", c.Request.Method, c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Clinic group not set"})
+		db := Models.DB.Session(&gorm.Session{NewDB: true})
+		db.AddError(errNoClinicGroup)
+		return db
+	}
+	// Changes made through it are attributed to the caller in the audit log
+	userID, _ := c.Get("userID")
+	actorID, _ := userID.(uint)
+	return auditedScopedDB(c, id, actorID)
+}
+
+// auditedScopedDB returns a session limited to the clinic group whose changes
+// are audited as made by actorID in this request. Routes without a staff user,
+// like public links and the patient portal, pass 0 so patients' changes are
+// audited without an actor.
+func auditedScopedDB(c *gin.Context, clinicGroupID uint, actorID uint) *gorm.DB {
+	return Models.WithAuditActor(Models.ScopedDB(clinicGroupID), actorID, c.GetString("requestID"))
+}
+
+// clinicLocation returns the time zone of the caller's clinic group
+func clinicLocation(c *gin.Context) *time.Location {
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+	return Models.ClinicGroupLocation(id)
+}
+
+// legacyClinicGroupID returns the clinic group set in LEGACY_CLINIC_GROUP_ID
+// to serve the public routes that aren't under /c/:slug, for frontends that
+// haven't moved to the slug routes yet. It is 0 when unset.
+func legacyClinicGroupID() uint {
+	value := os.Getenv("LEGACY_CLINIC_GROUP_ID")
+	if value == "" {
+		return 0
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		log.Printf("Invalid LEGACY_CLINIC_GROUP_ID %q: %v", value, err)
+		return 0
+	}
+	return uint(id)
+}
+
+// publicClinicGroupID resolves the clinic group of a public booking request:
+// the one of the /c/:slug route, else the logged in user's, else
+// LEGACY_CLINIC_GROUP_ID. When there is none it responds 404 and returns
+// false.
+func publicClinicGroupID(c *gin.Context) (uint, bool) {
+	if clinicGroupID, exists := c.Get("clinicGroupID"); exists {
+		if id, ok := clinicGroupID.(uint); ok && id != 0 {
+			return id, true
+		}
+	}
+	if user_id := staffUserID(c); user_id != 0 {
+		if id, err := Models.GetUserClinicGroupID(user_id); err == nil && id != 0 {
+			return id, true
+		}
+	}
+	if id := legacyClinicGroupID(); id != 0 {
+		log.Printf("%s without a clinic slug served by LEGACY_CLINIC_GROUP_ID %d", c.Request.URL.Path, id)
+		return id, true
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Clinic not found, use the /api/c/:slug routes"})
+	return 0, false
+}
+
+// staffUserID returns the user of a staff access token sent to a public
+// route, or 0 when there is none or its session was revoked.
+func staffUserID(c *gin.Context) uint {
+	if Token.TokenValid(c) != nil {
+		return 0
+	}
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil || user_id == 0 {
+		return 0
+	}
+	sessionID, err := Token.ExtractSessionID(c)
+	if err != nil || !Models.SessionActive(sessionID, user_id) {
+		return 0
+	}
+	return user_id
+}
+
+// clinicRecordExists reports whether the row of model with id belongs to the
+// caller's clinic group.
+func clinicRecordExists(c *gin.Context, model interface{}, id uint) bool {
+	var count int64
+	if err := getScopedDB(c).Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		log.Println(err)
+		return false
+	}
+	return count > 0
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// pageQuery reads the limit and offset of a paged list.
+func pageQuery(c *gin.Context) (int, int, bool) {
+	limit := defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return 0, 0, false
 		}
-		// Default to appointment_requests if no model name is set
+		limit = min(parsed, maxPageLimit)
 	}
+	offset := 0
+	if value := c.Query("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return 0, 0, false
+		}
+		offset = parsed
+	}
+	return limit, offset, true
+}
 
-	// Try the old way as fallback
-	return dbFunc("")
+// createdBetween filters query by the date_from and date_to parameters, both
+// inclusive days in the clinic's time zone.
+func createdBetween(c *gin.Context, query *gorm.DB) (*gorm.DB, bool) {
+	location := clinicLocation(c)
+	if value := c.Query("date_from"); value != "" {
+		from, err := time.ParseInLocation("2006-01-02", value, location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date_from format. Use YYYY-MM-DD"})
+			return nil, false
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if value := c.Query("date_to"); value != "" {
+		to, err := time.ParseInLocation("2006-01-02", value, location)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date_to format. Use YYYY-MM-DD"})
+			return nil, false
+		}
+		query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return query, true
 }
@@ -0,0 +1,200 @@
+package Controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/Token"
+	"PhysioUp/Whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ChangePassword replaces the caller's password. Their other sessions are
+// logged out.
+func ChangePassword(c *gin.Context) {
+	var input struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := Models.ValidatePassword(input.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be 8 to 72 characters"})
+		return
+	}
+
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sessionID, err := Token.ExtractSessionID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := Models.ChangePassword(Models.DB, user_id, sessionID, input.CurrentPassword, input.NewPassword); err != nil {
+		if errors.Is(err, Models.ErrPasswordIncorrect) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password Changed Successfully"})
+}
+
+// SetPhone changes the number the caller's password reset codes are sent
+// to. It asks for the password since the number can take over the account.
+func SetPhone(c *gin.Context) {
+	var input struct {
+		PhoneNumber string `json:"phone_number" binding:"required"`
+		Password    string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user Models.User
+	if err := Models.DB.Select("id", "password").First(&user, user_id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err := Models.VerifyPassword(input.Password, user.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	if err := Models.DB.Model(&Models.User{}).Where("id = ?", user_id).Update("phone", normalizePhone(input.PhoneNumber)).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update phone number"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Phone Number Updated Successfully"})
+}
+
+// ResetUserPassword gives a user of the caller's clinic group a temporary
+// password, shown only here, and logs them out everywhere.
+func ResetUserPassword(c *gin.Context) {
+	var input struct {
+		UserID uint `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.UserID == user_id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use ChangePassword for your own password"})
+		return
+	}
+
+	password, err := Models.ResetPassword(getScopedDB(c), input.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password Reset Successfully", "temporary_password": password})
+}
+
+// ForgotPassword sends a reset code over WhatsApp to the phone registered for
+// the username. The response is the same whether or not the user exists, so
+// rate limits and send failures are only logged.
+func ForgotPassword(c *gin.Context) {
+	var input struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user Models.User
+	if err := Models.DB.Select("id", "phone", "is_frozen").Where("username = ?", input.Username).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println(err)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Reset Code Sent If The Account Has A Phone Number"})
+		return
+	}
+	if user.Phone == "" || user.IsFrozen {
+		c.JSON(http.StatusOK, gin.H{"message": "Reset Code Sent If The Account Has A Phone Number"})
+		return
+	}
+
+	// Sent in the background so the response takes as long as for unknown users
+	go func() {
+		code, _, err := Models.IssuePasswordResetCode(Models.DB, user.ID)
+		if err == nil {
+			err = Whatsapp.SendMessage(user.Phone, passwordResetMessage(code))
+		}
+		if err != nil {
+			log.Printf("Password reset code for user %d not sent: %v", user.ID, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reset Code Sent If The Account Has A Phone Number"})
+}
+
+// ResetPassword sets a new password with the code sent by ForgotPassword and
+// logs the user out everywhere.
+func ResetPassword(c *gin.Context) {
+	var input struct {
+		Username    string `json:"username" binding:"required"`
+		Code        string `json:"code" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := Models.ValidatePassword(input.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be 8 to 72 characters"})
+		return
+	}
+
+	var user Models.User
+	if err := Models.DB.Select("id").Where("username = ?", input.Username).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println(err)
+		}
+		respondNoPendingOTP(c)
+		return
+	}
+
+	attemptsLeft, err := Models.ResetPasswordWithCode(Models.DB, user.ID, input.Code, input.NewPassword)
+	if err != nil {
+		if status, message := otpError(err); status != 0 {
+			c.JSON(status, gin.H{"error": message, "attempts_left": attemptsLeft})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password Reset Successfully"})
+}
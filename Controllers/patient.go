@@ -6,23 +6,16 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 func FetchFutureAppointments(c *gin.Context) {
-	var input struct {
-		PatientID uint `json:"patient_id"`
-	}
-
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
 	var appointments []Models.Appointment
-	if err := Models.DB.Model(&Models.Appointment{}).Where("patient_id = ?", input.PatientID).Find(&appointments).Error; err != nil {
+	if err := Models.DB.Model(&Models.Appointment{}).Where("patient_id = ?", authenticatedPatientID(c)).Find(&appointments).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -57,6 +50,11 @@ func FetchPatientFilesURLs(c *gin.Context) {
 		return
 	}
 
+	if !clinicRecordExists(c, &Models.Patient{}, input.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
+
 	// Read directory entries
 	entries, err := os.ReadDir(fmt.Sprintf("./PatientRecords/%v/", input.ID))
 	if err != nil {
@@ -95,14 +93,18 @@ func UploadPatientRecord(c *gin.Context) {
 	}
 
 	// Retrieve the patient ID from the form data
-	patientID := c.PostForm("id")
-	if patientID == "" {
+	patientID, err := strconv.ParseUint(c.PostForm("id"), 10, 64)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Patient ID is required"})
 		return
 	}
+	if !clinicRecordExists(c, &Models.Patient{}, uint(patientID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
 
 	// Create the directory if it doesn't exist
-	patientDir := fmt.Sprintf("./PatientRecords/%s/", patientID)
+	patientDir := fmt.Sprintf("./PatientRecords/%d/", patientID)
 	if err := os.MkdirAll(patientDir, os.ModePerm); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create patient directory"})
 		return
@@ -118,7 +120,7 @@ func UploadPatientRecord(c *gin.Context) {
 	files := form.File["files"] // "files" is the key used in the FormData
 	for _, file := range files {
 		// Create the file in the patient's directory
-		filePath := fmt.Sprintf("%s%s", patientDir, file.Filename)
+		filePath := fmt.Sprintf("%s%s", patientDir, filepath.Base(file.Filename))
 		out, err := os.Create(filePath)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to create the file"})
@@ -155,8 +157,13 @@ func DeletePatientRecord(c *gin.Context) {
 		return
 	}
 
+	if !clinicRecordExists(c, &Models.Patient{}, input.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
+
 	// Construct the file path
-	filePath := fmt.Sprintf("./PatientRecords/%v/%s", input.ID, input.FileName)
+	filePath := fmt.Sprintf("./PatientRecords/%v/%s", input.ID, filepath.Base(input.FileName))
 
 	// Check if the file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -193,8 +200,8 @@ func UpdatePatient(c *gin.Context) {
 		return
 	}
 	var patient Models.Patient
-	if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", input.ID).Find(&patient).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+	if err := getScopedDB(c).Model(&Models.Patient{}).Where("id = ?", input.ID).First(&patient).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
 		return
 	}
 
@@ -211,7 +218,7 @@ func UpdatePatient(c *gin.Context) {
 	patient.Diagnosis = input.Diagnosis
 	patient.Notes = input.Notes
 
-	if err := Models.DB.Save(&patient).Error; err != nil {
+	if err := getScopedDB(c).Save(&patient).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
 		return
 	}
@@ -241,7 +248,7 @@ func CreatePatient(c *gin.Context) {
 
 	input.ClinicGroupID = client_group_id.(uint)
 
-	if err := Models.DB.Create(&input).Error; err != nil {
+	if err := getScopedDB(c).Create(&input).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
 		return
 	}
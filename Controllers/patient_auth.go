@@ -0,0 +1,121 @@
+package Controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/Token"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Numbers without a country code are Egyptian
+func normalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	if !strings.HasPrefix(phone, "+") {
+		phone = "+2" + phone
+	}
+	return phone
+}
+
+// PatientLogin sends a login OTP over WhatsApp to the patient registered with
+// the phone number. The response is the same whether or not it is registered,
+// so rate limits and send failures are only logged.
+func PatientLogin(c *gin.Context) {
+	var input struct {
+		PhoneNumber string `json:"phone_number" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clinicGroupID, ok := publicClinicGroupID(c)
+	if !ok {
+		return
+	}
+
+	var patient Models.Patient
+	if err := Models.DB.Model(&Models.Patient{}).Where("phone = ? AND clinic_group_id = ?", normalizePhone(input.PhoneNumber), clinicGroupID).First(&patient).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println(err)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "OTP Sent Successfully"})
+		return
+	}
+
+	// Sent in the background so the response takes as long as for unknown numbers
+	go func() {
+		if err := sendPatientOTP(patient.ID, patient.Phone); err != nil {
+			log.Printf("Patient login OTP for patient %d not sent: %v", patient.ID, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, gin.H{"message": "OTP Sent Successfully"})
+}
+
+// VerifyPatientLogin checks the login OTP and returns a patient portal token.
+func VerifyPatientLogin(c *gin.Context) {
+	var input struct {
+		PhoneNumber string `json:"phone_number" binding:"required"`
+		OTP         string `json:"otp" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clinicGroupID, ok := publicClinicGroupID(c)
+	if !ok {
+		return
+	}
+
+	var patient Models.Patient
+	if err := Models.DB.Model(&Models.Patient{}).Where("phone = ? AND clinic_group_id = ?", normalizePhone(input.PhoneNumber), clinicGroupID).First(&patient).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println(err)
+		}
+		respondNoPendingOTP(c)
+		return
+	}
+
+	attemptsLeft, err := Models.VerifyPatientOTP(Models.DB, patient.ID, input.OTP)
+	if err != nil {
+		if status, message := otpError(err); status != 0 {
+			c.JSON(status, gin.H{"error": message, "attempts_left": attemptsLeft})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify OTP"})
+		return
+	}
+
+	respondWithPatientToken(c, patient)
+}
+
+func respondWithPatientToken(c *gin.Context, patient Models.Patient) {
+	token, err := Token.GeneratePatientToken(patient.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":        token,
+		"patient_id":   patient.ID,
+		"patient_name": patient.Name,
+		"phone_number": patient.Phone,
+	})
+}
+
+// authenticatedPatientID returns the patient set by PatientAuthMiddleware
+func authenticatedPatientID(c *gin.Context) uint {
+	patientID, _ := c.Get("patientID")
+	id, _ := patientID.(uint)
+	return id
+}
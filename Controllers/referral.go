@@ -32,7 +32,7 @@ func AddReferral(c *gin.Context) {
 
 	input.ClinicGroupID = client_group_id.(uint)
 
-	if err := Models.DB.Create(&input).Error; err != nil {
+	if err := getScopedDB(c).Create(&input).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -47,7 +47,11 @@ func EditReferral(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := Models.DB.Save(&input).Error; err != nil {
+	if !clinicRecordExists(c, &Models.Referral{}, input.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Referral not found"})
+		return
+	}
+	if err := getScopedDB(c).Save(&input).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -64,8 +68,13 @@ func DeleteReferral(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := Models.DB.Delete(&Models.Referral{}, "id = ?", input.ReferralID).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	result := getScopedDB(c).Delete(&Models.Referral{}, "id = ?", input.ReferralID)
+	if result.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Referral not found"})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -84,12 +93,12 @@ func FetchReferralPackages(c *gin.Context) {
 	}
 
 	var TreatmentPlans []Models.TreatmentPlan
-	if err := Models.DB.Model(&Models.TreatmentPlan{}).Where("referral_id = ?", input.ReferralID).Find(&TreatmentPlans).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.TreatmentPlan{}).Where("referral_id = ?", input.ReferralID).Find(&TreatmentPlans).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	for index := range TreatmentPlans {
-		if err := Models.DB.Model(&Models.SuperTreatmentPlan{}).Where("id = ?", TreatmentPlans[index].SuperTreatmentPlanID).Find(&TreatmentPlans[index].SuperTreatmentPlan).Error; err != nil {
+		if err := getScopedDB(c).Model(&Models.SuperTreatmentPlan{}).Where("id = ?", TreatmentPlans[index].SuperTreatmentPlanID).Find(&TreatmentPlans[index].SuperTreatmentPlan).Error; err != nil {
 			c.JSON(http.StatusOK, nil)
 			return
 		}
@@ -108,14 +117,19 @@ func SetPackageReferral(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if input.ReferralID != nil && !clinicRecordExists(c, &Models.Referral{}, *input.ReferralID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Referral not found"})
+		return
+	}
+
 	var TreatmentPlan Models.TreatmentPlan
 
-	if err := Models.DB.Model(&Models.TreatmentPlan{}).Where("id = ?", input.PackageID).First(&TreatmentPlan).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := getScopedDB(c).Model(&Models.TreatmentPlan{}).Where("id = ?", input.PackageID).First(&TreatmentPlan).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
 		return
 	}
 
-	if err := Models.DB.Model(&Models.SuperTreatmentPlan{}).Where("id = ?", TreatmentPlan.SuperTreatmentPlanID).Find(&TreatmentPlan.SuperTreatmentPlan).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.SuperTreatmentPlan{}).Where("id = ?", TreatmentPlan.SuperTreatmentPlanID).Find(&TreatmentPlan.SuperTreatmentPlan).Error; err != nil {
 		c.JSON(http.StatusOK, nil)
 		return
 	}
@@ -123,7 +137,7 @@ func SetPackageReferral(c *gin.Context) {
 	TreatmentPlan.ReferralID = input.ReferralID
 
 	TreatmentPlan.TotalPrice = TreatmentPlan.SuperTreatmentPlan.Price * ((100 - input.Discount) / 100)
-	if err := Models.DB.Save(&TreatmentPlan).Error; err != nil {
+	if err := getScopedDB(c).Save(&TreatmentPlan).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -5,8 +5,10 @@ import (
 	"PhysioUp/Models"
 	"PhysioUp/SSE"
 	"PhysioUp/Utils/Token"
+	"PhysioUp/Whatsapp"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
@@ -23,47 +25,77 @@ func RequestAppointment(c *gin.Context) {
 		return
 	}
 
-	tx := Models.DB.Begin()
-
-	// Check if the patient already has an appointment on the same day
-
-	var therapist Models.Therapist
-	if err := tx.Model(&Models.Therapist{}).Where("id = ?", input.TherapistID).Preload("Schedule.TimeBlocks").First(&therapist).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Bookings belong to the clinic of the route, not to the request body
+	clinicGroupID, ok := publicClinicGroupID(c)
+	if !ok {
 		return
 	}
+	input.ClinicGroupID = clinicGroupID
 
-	user_id, _ := Token.ExtractTokenID(c)
+	user_id := staffUserID(c)
 	var user Models.User
 	if user_id != 0 {
 		user, _ = Models.GetUserByID(user_id)
 	}
 
-	input.ClinicGroupID = user.ClinicGroupID
-	if user.Permission < 2 {
-		input.ClinicGroupID = 1
-		layoutWithLeadingZero := "2006/01/02 & 03:04 PM"
-		layoutWithoutLeadingZero := "2006/01/02 & 3:04 PM"
+	isStaff := user.Role.Can(Models.PermissionAppointments) && user.ClinicGroupID == input.ClinicGroupID
 
-		// Try parsing with both layouts
-		var parsedTime time.Time
-		var err error
+	// Requests made by patients are audited without an actor
+	var actorID uint
+	if isStaff {
+		actorID = user.ID
+	}
+	tx := auditedScopedDB(c, input.ClinicGroupID, actorID).Begin()
 
-		parsedTime, err = time.Parse(layoutWithLeadingZero, input.DateTime)
-		if err != nil {
-			// If the first layout fails, try the second layout
-			parsedTime, err = time.Parse(layoutWithoutLeadingZero, input.DateTime)
-			if err != nil {
-				fmt.Println("Error parsing date:", err)
-				return
-			}
+	var therapist Models.Therapist
+	if err := tx.Model(&Models.Therapist{}).
+		Joins("JOIN users ON therapists.user_id = users.id").
+		Where("therapists.id = ? AND users.clinic_group_id = ?", input.TherapistID, input.ClinicGroupID).
+		Preload("Schedule").First(&therapist).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	// Only staff and the logged in patient can book for an existing patient
+	if patientID, err := Token.ExtractPatientID(c); err == nil {
+		input.PatientID = patientID
+	} else if !isStaff && (input.PatientID != 0 || input.IsExisting) {
+		tx.Rollback()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Log in with your phone number to book as an existing patient"})
+		return
+	}
+
+	// Requests to join a group session take the session's time
+	var groupSession Models.TimeBlock
+	if input.TimeBlockID != nil {
+		if err := tx.Model(&Models.TimeBlock{}).Where("id = ? AND schedule_id = ? AND is_group = ?", *input.TimeBlockID, therapist.Schedule.ID, true).
+			Preload("Appointments").First(&groupSession).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group session not found"})
+			return
+		}
+		if groupSession.SeatsLeft() == 0 {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": "Group session is full"})
+			return
 		}
+		input.DateTime = groupSession.DateTime
+	}
+
+	if input.DateTime.IsZero() {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date time is required"})
+		return
+	}
 
-		currentTime := time.Now()
+	if input.TimeBlockID == nil {
+		input.DateTime = input.DateTime.WallClockIn(Models.ClinicGroupLocation(input.ClinicGroupID))
+	}
 
-		// Calculate the difference between the parsed time and the current time
-		timeDifference := parsedTime.Sub(currentTime)
+	if !isStaff {
+		// Calculate the difference between the requested time and the current time
+		timeDifference := input.DateTime.Sub(time.Now())
 
 		// Define two weeks duration
 		twoWeeks := 14 * 24 * time.Hour
@@ -75,11 +107,31 @@ func RequestAppointment(c *gin.Context) {
 		}
 	}
 
+	slot := Models.NewSlot(therapist, input.DateTime, 0)
+	if input.TimeBlockID != nil {
+		slot = groupSession.Slot()
+	}
+	if err := Models.CheckBookable(tx, therapist.ID, input.ClinicGroupID, slot); err != nil {
+		tx.Rollback()
+		if message := unavailabilityError(err); message != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check time block availability"})
+		return
+	}
+
 	// Check if the time block is already booked
-	for _, timeblock := range therapist.Schedule.TimeBlocks {
-		if timeblock.DateTime == input.DateTime {
+	if input.TimeBlockID == nil {
+		booked, err := Models.IsSlotBooked(tx, therapist.Schedule.ID, slot)
+		if err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Time Block already booked"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check time block availability"})
+			return
+		}
+		if booked {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": "Time Block already booked", "waitlist_available": true})
 			return
 		}
 	}
@@ -88,6 +140,7 @@ func RequestAppointment(c *gin.Context) {
 	input.TherapistName = therapist.Name
 	input.TherapistID = therapist.ID
 
+	isVerified := true
 	if input.PatientID == 0 {
 		// Begin Transaction
 
@@ -105,12 +158,12 @@ func RequestAppointment(c *gin.Context) {
 
 		var patient Models.Patient
 
-		if err := tx.Model(&Models.Patient{}).Where("phone = ?", input.PhoneNumber).First(&patient).Error; true {
+		if err := tx.Model(&Models.Patient{}).Where("phone = ? AND clinic_group_id = ?", input.PhoneNumber, input.ClinicGroupID).First(&patient).Error; true {
 			if errors.Is(err, gorm.ErrRecordNotFound) && !input.IsExisting {
 				patient.Name = input.PatientName
 				patient.Phone = input.PhoneNumber
-				patient.GenerateOTPToken(6)
-				patient.IsVerified = true
+				// Patients booking for themselves confirm their phone with an OTP first
+				patient.IsVerified = isStaff
 				patient.ClinicGroupID = input.ClinicGroupID
 				if err := tx.Create(&patient).Error; err != nil {
 					tx.Rollback()
@@ -132,9 +185,10 @@ func RequestAppointment(c *gin.Context) {
 		input.PatientName = patient.Name
 		var existingAppointmentRequests []Models.AppointmentRequest
 		var existingAppointments []Models.Appointment
+		dayStart, dayEnd := input.DateTime.DayBounds()
 
 		if err := tx.Model(&Models.AppointmentRequest{}).
-			Where("patient_id = ? AND DATE(date_time) = ?", input.PatientID, input.DateTime).
+			Where("patient_id = ? AND date_time >= ? AND date_time < ?", input.PatientID, dayStart, dayEnd).
 			Find(&existingAppointmentRequests).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to check existing appointments"})
@@ -142,7 +196,7 @@ func RequestAppointment(c *gin.Context) {
 		}
 
 		if err := tx.Model(&Models.Appointment{}).
-			Where("patient_id = ? AND DATE(date_time) = ?", input.PatientID, input.DateTime).
+			Where("patient_id = ? AND date_time >= ? AND date_time < ?", input.PatientID, dayStart, dayEnd).
 			Find(&existingAppointments).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to check existing appointments"})
@@ -156,15 +210,17 @@ func RequestAppointment(c *gin.Context) {
 		}
 		input.PatientName = patient.Name
 		input.PhoneNumber = patient.Phone
+		isVerified = patient.IsVerified
 	} else {
 		var patient Models.Patient
-		if err := tx.Model(&Models.Patient{}).Where("id = ?", input.PatientID).First(&patient).Error; err != nil {
+		if err := tx.Model(&Models.Patient{}).Where("id = ? AND clinic_group_id = ?", input.PatientID, input.ClinicGroupID).First(&patient).Error; err != nil {
 			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Couldn't Create Patient"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
 			return
 		}
 		input.PatientName = patient.Name
 		input.PhoneNumber = patient.Phone
+		isVerified = patient.IsVerified
 	}
 
 	defer func() {
@@ -184,18 +240,60 @@ func RequestAppointment(c *gin.Context) {
 	// Commit the transaction if everything is successful
 	tx.Commit()
 	SSE.Broadcaster.Broadcast("refresh")
-	c.SetCookie("patient_id", fmt.Sprintf("%d", input.PatientID), 3600*24*14, "/", "/", false, false)
-	c.SetCookie("phone_number", fmt.Sprintf("%s", input.PhoneNumber), 3600*24*14, "/", "/", false, false)
-	c.SetCookie("patient_name", fmt.Sprintf("%s", input.PatientName), 3600*24*14, "/", "/", false, false)
+	// Requests of unverified patients stay hidden until the OTP is confirmed
+	if !isVerified {
+		if err := sendPatientOTP(input.PatientID, input.PhoneNumber); err != nil && !errors.Is(err, Models.ErrOTPResendTooSoon) {
+			log.Println(err)
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Requested Successfully",
 		"appointment_id": input.ID,
 		"patient_id":     input.PatientID,
+		"otp_required":   !isVerified,
 	})
 }
 
+// sendPatientOTP issues a new verification code for the patient and sends it
+// over WhatsApp.
+func sendPatientOTP(patientID uint, phone string) error {
+	code, _, err := Models.IssuePatientOTP(Models.DB, patientID)
+	if err != nil {
+		return err
+	}
+	return Whatsapp.SendMessage(phone, otpMessage(code))
+}
+
+// otpError maps the OTP errors to a status and message, or returns 0 for
+// other errors.
+func otpError(err error) (int, string) {
+	switch {
+	case errors.Is(err, Models.ErrOTPInvalid):
+		return http.StatusBadRequest, "Incorrect OTP"
+	case errors.Is(err, Models.ErrOTPExpired):
+		return http.StatusBadRequest, "OTP expired, request a new one"
+	case errors.Is(err, Models.ErrOTPLocked):
+		return http.StatusTooManyRequests, "Too many attempts, try again later"
+	case errors.Is(err, Models.ErrOTPResendTooSoon):
+		return http.StatusTooManyRequests, "An OTP was sent recently, try again shortly"
+	}
+	return 0, ""
+}
+
+// respondNoPendingOTP answers a code check for an unknown phone number or
+// username the way a known one without a pending code is answered, so the
+// response doesn't tell whether it exists.
+func respondNoPendingOTP(c *gin.Context) {
+	status, message := otpError(Models.ErrOTPExpired)
+	c.JSON(status, gin.H{"error": message, "attempts_left": 0})
+}
+
 func FetchRequestedAppointments(c *gin.Context) {
-	c.Set("modelName", "appointment_requests")
+	branchID, ok := branchQuery(c)
+	if !ok {
+		return
+	}
+
 	db := getScopedDB(c)
 	fmt.Println("Fetching requested appointments...")
 
@@ -206,6 +304,10 @@ func FetchRequestedAppointments(c *gin.Context) {
 		Select("appointment_requests.*"). // Makes it clear which table's columns we want
 		Joins("JOIN patients ON patients.id = appointment_requests.patient_id").
 		Where("patients.is_verified = ?", true)
+	if branchID != nil {
+		query = query.Where("appointment_requests.therapist_id IN (?)",
+			db.Session(&gorm.Session{NewDB: true}).Model(&Models.Therapist{}).Select("id").Where("branch_id = ?", *branchID))
+	}
 
 	fmt.Println("SQL Query:", query.Statement.SQL.String())
 
@@ -220,10 +322,19 @@ func FetchRequestedAppointments(c *gin.Context) {
 }
 
 func FetchUnassignedAppointments(c *gin.Context) {
+	branchID, ok := branchQuery(c)
+	if !ok {
+		return
+	}
+
 	db := getScopedDB(c)
+	query := db.Model(&Models.Appointment{}).Where("treatment_plan_id IS null")
+	if branchID != nil {
+		query = query.Where("branch_id = ?", *branchID)
+	}
+
 	var output []Models.Appointment
-	if err := db.Model(&Models.Appointment{}).
-		Where("treatment_plan_id IS null").Find(&output).Error; err != nil {
+	if err := query.Find(&output).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -249,7 +360,7 @@ func FetchPatientCurrentPackage(c *gin.Context) {
 
 	// First, get the latest treatment plan ID
 	var treatmentPlan Models.TreatmentPlan
-	if err := Models.DB.Model(&Models.TreatmentPlan{}).
+	if err := getScopedDB(c).Model(&Models.TreatmentPlan{}).
 		Where("patient_id = ?", input.PatientID).
 		Order("created_at DESC").
 		First(&treatmentPlan).Error; err != nil {
@@ -263,7 +374,7 @@ func FetchPatientCurrentPackage(c *gin.Context) {
 	}
 
 	// Get the super treatment plan details
-	if err := Models.DB.Model(&Models.SuperTreatmentPlan{}).
+	if err := getScopedDB(c).Model(&Models.SuperTreatmentPlan{}).
 		Where("id = ?", treatmentPlan.SuperTreatmentPlanID).
 		First(&treatmentPlan.SuperTreatmentPlan).Error; err != nil {
 		log.Printf("Error fetching super treatment plan: %v", err)
@@ -273,7 +384,7 @@ func FetchPatientCurrentPackage(c *gin.Context) {
 
 	// Count the appointments in a separate query
 	var appointmentCount int64
-	if err := Models.DB.Model(&Models.Appointment{}).
+	if err := getScopedDB(c).Model(&Models.Appointment{}).
 		Where("treatment_plan_id = ?", treatmentPlan.ID).
 		Count(&appointmentCount).Error; err != nil {
 		log.Printf("Error counting appointments: %v", err)
@@ -302,13 +413,13 @@ func FetchPatientPackages(c *gin.Context) {
 		return
 	}
 	var Packages []Models.TreatmentPlan
-	if err := Models.DB.Model(&Models.TreatmentPlan{}).Where("patient_id = ?", input.PatientID).Find(&Packages).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.TreatmentPlan{}).Where("patient_id = ?", input.PatientID).Find(&Packages).Error; err != nil {
 		c.JSON(http.StatusOK, nil)
 		return
 	}
 
 	for index := range Packages {
-		if err := Models.DB.Model(&Models.SuperTreatmentPlan{}).Where("id = ?", Packages[index].SuperTreatmentPlanID).Find(&Packages[index].SuperTreatmentPlan).Error; err != nil {
+		if err := getScopedDB(c).Model(&Models.SuperTreatmentPlan{}).Where("id = ?", Packages[index].SuperTreatmentPlanID).Find(&Packages[index].SuperTreatmentPlan).Error; err != nil {
 			c.JSON(http.StatusOK, nil)
 			return
 		}
@@ -327,10 +438,16 @@ func MarkPackageAsPaid(c *gin.Context) {
 		c.JSON(http.StatusOK, nil)
 		return
 	}
-	if err := Models.DB.Model(&Models.TreatmentPlan{}).Where("id = ?", input.PackageID).Update("is_paid", true).Update("payment_method", input.PaymentMethod).Error; err != nil {
+	result := getScopedDB(c).Model(&Models.TreatmentPlan{}).Where("id = ?", input.PackageID).
+		Updates(map[string]interface{}{"is_paid": true, "payment_method": input.PaymentMethod})
+	if result.Error != nil {
 		c.JSON(http.StatusOK, nil)
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Marked Successfully"})
 }
 
@@ -344,10 +461,16 @@ func UnMarkPackageAsPaid(c *gin.Context) {
 		c.JSON(http.StatusOK, nil)
 		return
 	}
-	if err := Models.DB.Model(&Models.TreatmentPlan{}).Where("id = ?", input.PackageID).Update("is_paid", false).Update("payment_method", "").Error; err != nil {
+	result := getScopedDB(c).Model(&Models.TreatmentPlan{}).Where("id = ?", input.PackageID).
+		Updates(map[string]interface{}{"is_paid": false, "payment_method": ""})
+	if result.Error != nil {
 		c.JSON(http.StatusOK, nil)
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Marked Successfully"})
 }
 
@@ -359,8 +482,12 @@ func FetchPackageAppointments(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !clinicRecordExists(c, &Models.TreatmentPlan{}, input.PackageID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
 	var Appointments []Models.Appointment
-	if err := Models.DB.Model(&Models.Appointment{}).Where("treatment_plan_id = ?", input.PackageID).Find(&Appointments).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.Appointment{}).Where("treatment_plan_id = ?", input.PackageID).Find(&Appointments).Error; err != nil {
 		c.JSON(http.StatusOK, nil)
 		return
 	}
@@ -371,7 +498,7 @@ func FetchPackageAppointments(c *gin.Context) {
 func VerifyAppointmentRequestPhoneNo(c *gin.Context) {
 	var input struct {
 		ID  uint   `json:"ID"`
-		OTP string `json:"otp"`
+		OTP string `json:"otp" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -380,77 +507,103 @@ func VerifyAppointmentRequestPhoneNo(c *gin.Context) {
 	}
 
 	var patientID uint
-
 	if err := Models.DB.Model(&Models.AppointmentRequest{}).Where("id = ?", input.ID).Select("patient_id").First(&patientID).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment request not found"})
 		return
 	}
 
-	var patientOTP string
-	var patientPhone string
-	if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", patientID).Select("phone").First(&patientPhone).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", patientID).Select("otp").First(&patientOTP).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	fmt.Println(input.ID)
-	fmt.Println(patientOTP)
-	if patientOTP == input.OTP {
-		if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", patientID).Update("is_verified", true).Error; err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	attemptsLeft, err := Models.VerifyPatientOTP(Models.DB, patientID, input.OTP)
+	if err != nil {
+		if status, message := otpError(err); status != 0 {
+			c.JSON(status, gin.H{"error": message, "attempts_left": attemptsLeft})
 			return
 		}
-		SSE.Broadcaster.Broadcast("refresh")
-		// client := twilio.NewRestClient()
-
-		// params := &api.CreateMessageParams{}
-		// params.SetBody(fmt.Sprintf("Your ID for future appointments is: %v", patientID))
-		// params.SetFrom("+15076936009")
-		// params.SetTo(patientPhone)
-
-		// resp, err := client.Api.CreateMessage(params)
-		// if err != nil {
-		// 	fmt.Println(err.Error())
-		// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP"})
-		// 	return
-		// } else {
-		// 	if resp.Body != nil {
-		// 		fmt.Println(*resp.Body)
-		// 	} else {
-		// 		fmt.Println(resp.Body)
-		// 	}
-		// }
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify OTP"})
+		return
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+
+	// Confirming the phone also logs the patient in to the portal
+	var patient Models.Patient
+	if err := Models.DB.First(&patient, patientID).Error; err != nil {
+		log.Println(err)
 		c.JSON(http.StatusOK, gin.H{"message": "Phone Number Confirmed"})
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": errors.New("Incorrect OTP")})
 		return
 	}
+	token, err := Token.GeneratePatientToken(patient.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Phone Number Confirmed", "token": token, "patient_id": patient.ID})
 }
 
-func FetchAppointmentsByPatientID(c *gin.Context) {
+// ResendAppointmentRequestOTP sends a new verification code for the patient
+// of an appointment request.
+func ResendAppointmentRequestOTP(c *gin.Context) {
 	var input struct {
-		ID uint `json:"id"`
+		ID uint `json:"ID" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	var patient Models.Patient
+	if err := Models.DB.Model(&Models.Patient{}).
+		Joins("JOIN appointment_requests ON appointment_requests.patient_id = patients.id").
+		Where("appointment_requests.id = ? AND appointment_requests.deleted_at IS NULL", input.ID).
+		First(&patient).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment request not found"})
+		return
+	}
+	if patient.IsVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number already verified"})
+		return
+	}
+
+	code, retryAt, err := Models.IssuePatientOTP(Models.DB, patient.ID)
+	if err != nil {
+		if status, message := otpError(err); status != 0 {
+			c.JSON(status, gin.H{"error": message, "retry_after": int(time.Until(retryAt).Seconds()) + 1})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP"})
+		return
+	}
+	if err := Whatsapp.SendMessage(patient.Phone, otpMessage(code)); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send OTP"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OTP Sent Successfully", "retry_after": int(time.Until(retryAt).Seconds()) + 1})
+}
+
+func FetchAppointmentsByPatientID(c *gin.Context) {
+	patientID := authenticatedPatientID(c)
+
 	// For Appointments: Select only the required fields
 	type AppointmentResponse struct {
-		ID            uint   `json:"id"`
-		DateTime      string `json:"date_time"`
-		TherapistName string `json:"therapist_name"`
-		IsCompleted   bool   `json:"is_completed"`
+		ID            uint            `json:"id"`
+		DateTime      Models.DateTime `json:"date_time"`
+		TherapistName string          `json:"therapist_name"`
+		IsCompleted   bool            `json:"is_completed"`
 	}
 
+	var clinicGroupID uint
+	if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", patientID).Select("clinic_group_id").Scan(&clinicGroupID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	location := Models.ClinicGroupLocation(clinicGroupID)
+
 	var appointmentResponses []AppointmentResponse
 	if err := Models.DB.Model(&Models.Appointment{}).
 		Select("id, date_time, therapist_name, is_completed").
-		Where("patient_id = ?", input.ID).
+		Where("patient_id = ?", patientID).
 		Find(&appointmentResponses).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -458,42 +611,51 @@ func FetchAppointmentsByPatientID(c *gin.Context) {
 
 	// For AppointmentRequests: Select only the required fields
 	type RequestResponse struct {
-		ID            uint   `json:"id"`
-		DateTime      string `json:"date_time"`
-		TherapistName string `json:"therapist_name"`
+		ID            uint            `json:"id"`
+		DateTime      Models.DateTime `json:"date_time"`
+		TherapistName string          `json:"therapist_name"`
 	}
 
 	var requestResponses []RequestResponse
 	if err := Models.DB.Model(&Models.AppointmentRequest{}).
 		Select("id, date_time, therapist_name").
-		Where("patient_id = ?", input.ID).
+		Where("patient_id = ?", patientID).
 		Find(&requestResponses).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	for index := range appointmentResponses {
+		appointmentResponses[index].DateTime = appointmentResponses[index].DateTime.InLocation(location)
+	}
+	for index := range requestResponses {
+		requestResponses[index].DateTime = requestResponses[index].DateTime.InLocation(location)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"appointments": appointmentResponses,
 		"requests":     requestResponses,
 	})
 }
 
+// GetPatientIdByPhone returns the logged in patient's ID. A phone number that
+// isn't theirs is rejected.
 func GetPatientIdByPhone(c *gin.Context) {
 	var input struct {
 		PhoneNumber string `json:"phone_number"`
 	}
-	if err := c.ShouldBindJSON(&input); err != nil {
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if !strings.HasPrefix(input.PhoneNumber, "+") {
-		input.PhoneNumber = "+2" + input.PhoneNumber
-	}
-
 	var patient Models.Patient
-	if err := Models.DB.Where("phone = ?", input.PhoneNumber).First(&patient).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No patient found with this phone number"})
+	if err := Models.DB.First(&patient, authenticatedPatientID(c)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
+	if input.PhoneNumber != "" && normalizePhone(input.PhoneNumber) != patient.Phone {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Phone number doesn't belong to this patient"})
 		return
 	}
 
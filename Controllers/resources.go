@@ -0,0 +1,152 @@
+package Controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"PhysioUp/Models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resourceError maps the allocation errors of Models.AllocateResources to a
+// message, or returns "" for other errors.
+func resourceError(err error) string {
+	switch {
+	case errors.Is(err, Models.ErrNoRoomAvailable):
+		return "No room is free at this time"
+	case errors.Is(err, Models.ErrNoEquipmentAvailable):
+		return "No equipment of the required type is free at this time"
+	}
+	return ""
+}
+
+func FetchRooms(c *gin.Context) {
+	branchID, ok := branchQuery(c)
+	if !ok {
+		return
+	}
+
+	db := getScopedDB(c)
+	var rooms []Models.Room
+	if err := db.Model(&Models.Room{}).Scopes(Models.InBranch(branchID)).Order("name").Find(&rooms).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rooms)
+}
+
+func AddRoom(c *gin.Context) {
+	var input struct {
+		Name     string `json:"name" binding:"required"`
+		BranchID *uint  `json:"branch_id"` // nil shares the room with every branch
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkBranch(c, input.BranchID) {
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+
+	room := Models.Room{Name: strings.TrimSpace(input.Name), ClinicGroupID: id, BranchID: input.BranchID}
+	if err := getScopedDB(c).Create(&room).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add room"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Room Added Successfully", "id": room.ID})
+}
+
+func RemoveRoom(c *gin.Context) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := getScopedDB(c).Delete(&Models.Room{}, input.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Room not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Room Removed Successfully"})
+}
+
+func FetchEquipment(c *gin.Context) {
+	branchID, ok := branchQuery(c)
+	if !ok {
+		return
+	}
+
+	db := getScopedDB(c)
+	var equipment []Models.Equipment
+	if err := db.Model(&Models.Equipment{}).Scopes(Models.InBranch(branchID)).Order("type, name").Find(&equipment).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, equipment)
+}
+
+func AddEquipment(c *gin.Context) {
+	var input struct {
+		Name     string `json:"name" binding:"required"`
+		Type     string `json:"type" binding:"required"`
+		BranchID *uint  `json:"branch_id"` // nil shares the equipment with every branch
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkBranch(c, input.BranchID) {
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+
+	equipment := Models.Equipment{
+		Name:          strings.TrimSpace(input.Name),
+		Type:          strings.ToLower(strings.TrimSpace(input.Type)),
+		ClinicGroupID: id,
+		BranchID:      input.BranchID,
+	}
+	if err := getScopedDB(c).Create(&equipment).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add equipment"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Equipment Added Successfully", "id": equipment.ID})
+}
+
+func RemoveEquipment(c *gin.Context) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := getScopedDB(c).Delete(&Models.Equipment{}, input.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Equipment not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Equipment Removed Successfully"})
+}
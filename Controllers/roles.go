@@ -0,0 +1,149 @@
+package Controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/Token"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FetchRoles returns every role with the permissions it grants.
+func FetchRoles(c *gin.Context) {
+	type roleDTO struct {
+		Role        Models.Role         `json:"role"`
+		Permissions []Models.Permission `json:"permissions"`
+	}
+	roles := []roleDTO{}
+	for _, role := range Models.Roles {
+		roles = append(roles, roleDTO{Role: role, Permissions: role.Permissions()})
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+func FetchStaff(c *gin.Context) {
+	type staffDTO struct {
+		ID       uint        `json:"ID"`
+		Username string      `json:"username"`
+		Role     Models.Role `json:"role"`
+		IsFrozen bool        `json:"is_frozen"`
+	}
+	var staff []staffDTO
+	if err := getScopedDB(c).Model(&Models.User{}).Select("id", "username", "role", "is_frozen").Order("username").Scan(&staff).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load staff"})
+		return
+	}
+	c.JSON(http.StatusOK, staff)
+}
+
+// AssignRole changes the role of a user in the caller's clinic group. The
+// group always keeps at least one owner who isn't frozen.
+func AssignRole(c *gin.Context) {
+	var input struct {
+		UserID uint        `json:"user_id" binding:"required"`
+		Role   Models.Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !input.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	tx := getScopedDB(c).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var user Models.User
+	if err := tx.Model(&Models.User{}).Where("id = ?", input.UserID).First(&user).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.Role == Models.RoleOwner && input.Role != Models.RoleOwner {
+		// Owners are locked so two owners can't demote each other at once
+		var owners []Models.User
+		if err := tx.Model(&Models.User{}).Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "is_frozen").Where("role = ?", Models.RoleOwner).Find(&owners).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+		// Frozen owners can't log in, so they don't count
+		activeOwners := 0
+		for _, owner := range owners {
+			if owner.ID != user.ID && !owner.IsFrozen {
+				activeOwners++
+			}
+		}
+		if activeOwners == 0 {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": "A clinic group needs at least one active owner"})
+			return
+		}
+	}
+
+	if err := tx.Model(&Models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"role":       input.Role,
+		"permission": input.Role.LegacyPermission(),
+	}).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role Updated Successfully"})
+}
+
+// SetUserFrozen freezes or unfreezes a user of the caller's clinic group.
+// A frozen user is logged out everywhere and can't log in again.
+func SetUserFrozen(c *gin.Context) {
+	var input struct {
+		UserID uint `json:"user_id" binding:"required"`
+		Frozen bool `json:"frozen"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Frozen && input.UserID == user_id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't freeze yourself"})
+		return
+	}
+
+	if err := Models.SetUserFrozen(getScopedDB(c), input.UserID, input.Frozen); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User Updated Successfully"})
+}
@@ -1,6 +1,7 @@
 package Controllers
 
 import (
+	"PhysioUp/Constants"
 	"PhysioUp/FirebaseMessaging"
 	"PhysioUp/Models"
 	"PhysioUp/SSE"
@@ -9,17 +10,20 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
 )
 
 func AcceptAppointment(c *gin.Context) {
 	var input struct {
 		AppointmentRequestID uint               `json:"appointment_request_id"`
 		Extra                Models.Appointment `json:"extra"`
+		SuperTreatmentPlanID uint               `json:"super_treatment_plan_id"`
+		DurationMinutes      uint               `json:"duration_minutes"`
+		TimeBlockID          uint               `json:"time_block_id"` // group session to join
 		// TreatmentPlan        Models.TreatmentPlan `json:"treatment_plan"`
 	}
 
@@ -31,7 +35,7 @@ func AcceptAppointment(c *gin.Context) {
 
 	// input.TreatmentPlan.Date = time.Now().Format("2006-01-02")
 	// Start a transaction
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback() // Rollback the transaction in case of panic
@@ -55,41 +59,73 @@ func AcceptAppointment(c *gin.Context) {
 	appointment.PatientID = appointmentRequest.PatientID
 	appointment.TreatmentPlanID = nil
 	appointment.ClinicGroupID = appointmentRequest.ClinicGroupID
-	appointmentTime, err := time.Parse("2006/01/02 & 3:04 PM", appointmentRequest.DateTime)
+	if appointment.DateTime.IsZero() {
+		appointment.DateTime = appointmentRequest.DateTime
+	} else {
+		appointment.DateTime = appointment.DateTime.WallClockIn(Models.ClinicGroupLocation(appointment.ClinicGroupID))
+	}
+	appointmentTime := appointmentRequest.DateTime
 	if appointmentTime.After(time.Now()) {
 		appointment.ReminderSent = true
 	}
 	// Check therapist's schedule for conflicts
 	var therapist Models.Therapist
-	if err := tx.Model(&Models.Therapist{}).Where("id = ?", appointment.TherapistID).Preload("Schedule.TimeBlocks").Find(&therapist).Error; err != nil {
+	if err := tx.Model(&Models.Therapist{}).Where("id = ?", appointment.TherapistID).First(&therapist).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Therapist not found"})
 		return
 	}
 
-	therapist.Schedule.TherapistID = appointment.TherapistID
-
-	for _, timeblock := range therapist.Schedule.TimeBlocks {
-		if timeblock.DateTime == appointment.DateTime {
-			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Time block already booked"})
-			return
-		}
+	schedule, err := findOrCreateSchedule(tx, therapist.ID)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load therapist schedule"})
+		return
 	}
 
-	// Create a new time block
-	timeBlock := Models.CreateTimeBlock(therapist.Schedule, appointment)
-	therapist.Schedule.TimeBlocks = append(therapist.Schedule.TimeBlocks, timeBlock)
+	groupSessionID := input.TimeBlockID
+	if groupSessionID == 0 && appointmentRequest.TimeBlockID != nil {
+		groupSessionID = *appointmentRequest.TimeBlockID
+	}
 
-	if err := tx.Model(&therapist.Schedule).Where("id = ?", therapist.Schedule.ID).Association("TimeBlocks").Replace(&therapist.Schedule.TimeBlocks); err != nil {
-		log.Println(err)
+	var timeBlock Models.TimeBlock
+	if groupSessionID != 0 {
+		timeBlock, err = Models.JoinGroupSession(tx, therapist, groupSessionID)
+		if err == nil && timeBlock.ScheduleID != schedule.ID {
+			err = Models.ErrNotGroupSession
+		}
+		appointment.DateTime = timeBlock.DateTime
+	} else {
+		// An explicit duration wins over the package's, which wins over the therapist's
+		var plan Models.SuperTreatmentPlan
+		if input.SuperTreatmentPlanID != 0 {
+			if err := tx.Model(&Models.SuperTreatmentPlan{}).Where("id = ? AND clinic_group_id = ?", input.SuperTreatmentPlanID, appointment.ClinicGroupID).First(&plan).Error; err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Super treatment plan not found"})
+				return
+			}
+		}
+		durationMinutes := input.DurationMinutes
+		if durationMinutes == 0 {
+			durationMinutes = plan.SessionMinutes
+		}
+		timeBlock, err = bookSession(tx, therapist, schedule, appointment.ClinicGroupID, Models.NewSlot(therapist, appointment.DateTime, durationMinutes), plan.Resources())
+	}
+	if err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update therapist schedule"})
+		if message := bookingError(err); message != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update therapist schedule"})
 		return
 	}
 
 	// Associate the appointment with the time block
-	if err := tx.Model(&therapist.Schedule.TimeBlocks[len(therapist.Schedule.TimeBlocks)-1]).Association("Appointment").Replace(&appointment); err != nil {
+	appointment.TimeBlockID = timeBlock.ID
+	if err := tx.Create(&appointment).Error; err != nil {
 		log.Println(err)
 		tx.Rollback()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to associate appointment with time block"})
@@ -124,81 +160,7 @@ func AcceptAppointment(c *gin.Context) {
 	SSE.Broadcaster.Broadcast("refresh")
 
 	if appointmentTime.After(time.Now()) {
-		// Split the datetime string
-		// Split the datetime string
-		datetimeParts := strings.Split(appointmentRequest.DateTime, " & ")
-		date := datetimeParts[0]
-		time := datetimeParts[1]
-
-		// Reformat date from yyyy/MM/dd to dd/MM/yyyy
-		dateParts := strings.Split(date, "/")
-		if len(dateParts) == 3 {
-			// Rearrange from yyyy/MM/dd to dd/MM/yyyy
-			date = fmt.Sprintf("%s/%s/%s", dateParts[2], dateParts[1], dateParts[0])
-		}
-
-		// Remove "Dr." prefix if it exists
-		therapistName := appointmentRequest.TherapistName
-		therapistName = strings.TrimPrefix(therapistName, "Dr. ")
-		therapistName = strings.TrimPrefix(therapistName, "د. ")
-		therapistName = strings.TrimPrefix(therapistName, "Dr.")
-		therapistName = strings.TrimPrefix(therapistName, "د.")
-
-		// Convert date to Arabic format (replace Western numbers with Arabic numbers)
-		arabicDate := strings.NewReplacer(
-			"0", "٠",
-			"1", "١",
-			"2", "٢",
-			"3", "٣",
-			"4", "٤",
-			"5", "٥",
-			"6", "٦",
-			"7", "٧",
-			"8", "٨",
-			"9", "٩",
-			"/", "/",
-		).Replace(date)
-
-		// Convert time to Arabic format
-		arabicTime := strings.NewReplacer(
-			"0", "٠",
-			"1", "١",
-			"2", "٢",
-			"3", "٣",
-			"4", "٤",
-			"5", "٥",
-			"6", "٦",
-			"7", "٧",
-			"8", "٨",
-			"9", "٩",
-			"AM", "صباحًا",
-			"PM", "مساءً",
-		).Replace(time)
-
-		message := fmt.Sprintf("🗓️ *APPOINTMENT CONFIRMATION* 🗓️\\n\\n"+
-			"Dear Patient,\\n\\n"+
-			"Your appointment has been confirmed:\\n"+
-			"• *Date:* %s\\n"+
-			"• *Time:* %s\\n"+
-			"• *Therapist:* Dr. %s\\n\\n"+
-			"✅ *تأكيد الموعد* ✅\\n\\n"+
-			"عزيزي المريض،\\n\\n"+
-			"تم تأكيد موعدك:\\n"+
-			"• *التاريخ:* %s\\n"+
-			"• *الوقت:* %s\\n"+
-			"• *دكتور:* %s\\n\\n"+
-			"Please make sure to arrive on time.\\n"+
-			"يرجى التأكد من الوصول في الموعد المحدد.\\n\\n"+
-			"We look forward to seeing you! Thank you for choosing PhysioUP.\\n"+
-			"نتطلع لرؤيتك! شكراً لاختيارك PhysioUP.",
-			date,
-			time,
-			therapistName,
-			arabicDate,
-			arabicTime,
-			therapistName)
-
-		Whatsapp.SendMessage(appointmentRequest.PhoneNumber, message)
+		Whatsapp.SendMessage(appointmentRequest.PhoneNumber, appointmentConfirmationMessage(appointment.DateTime, appointmentRequest.TherapistName, appointmentManageLink(appointment)))
 	}
 }
 
@@ -214,7 +176,7 @@ func RegisterAppointment(c *gin.Context) {
 		return
 	}
 
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println("paniced")
@@ -241,6 +203,9 @@ func RegisterAppointment(c *gin.Context) {
 		}
 
 		input.TreatmentPlan.PatientID = appointment.PatientID
+		if input.TreatmentPlan.Date == "" {
+			input.TreatmentPlan.Date = Models.Today(Models.ClinicGroupLocation(appointment.ClinicGroupID)).Format("2006-01-02")
+		}
 		input.TreatmentPlan.TotalPrice = input.TreatmentPlan.SuperTreatmentPlan.Price * ((100 - input.TreatmentPlan.Discount) / 100)
 		input.TreatmentPlan.Remaining = input.TreatmentPlan.SuperTreatmentPlan.SessionsCount
 
@@ -294,7 +259,7 @@ func RejectAppointment(c *gin.Context) {
 	}
 
 	// Start a transaction
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback() // Rollback the transaction in case of panic
@@ -333,15 +298,16 @@ func RejectAppointment(c *gin.Context) {
 		FirebaseMessaging.SendMessage(Models.NotificationRequest{Tokens: fcms, Title: "An Appointment Has Been Rejected", Body: fmt.Sprintf("Your appointment at %s with %s has been rejected", appointmentReq.DateTime, appointmentReq.PatientName)})
 	}
 	SSE.Broadcaster.Broadcast("refresh")
-	appointmentTime, err := time.Parse("2006/01/02 & 3:04 PM", appointmentReq.DateTime)
-
-	if appointmentTime.After(time.Now()) {
-		message := fmt.Sprintf("❌ *APPOINTMENT REJECTED* ❌\\n\\n" +
-			"Dear Patient,\\n\\n" +
-			"We're sorry, but your appointment request has been rejected. Please contact the clinic to reschedule or for further information.\\n\\n" +
-			"❌ *تم رفض الموعد* ❌\\n\\n" +
-			"عزيزي المريض،\\n\\n" +
-			"نعتذر، ولكن تم رفض طلب موعدك. يرجى الاتصال بالعيادة لإعادة الحجز أو للحصول على مزيد من المعلومات.")
+	go OfferFreedSlot(appointmentReq.TherapistID, appointmentReq.DateTime)
+	if appointmentReq.DateTime.After(time.Now()) {
+		message := fmt.Sprintf("❌ *APPOINTMENT REJECTED* ❌\\n\\n"+
+			"Dear Patient,\\n\\n"+
+			"We're sorry, but your appointment request has been rejected. You can request another time at %s\\n\\n"+
+			"❌ *تم رفض الموعد* ❌\\n\\n"+
+			"عزيزي المريض،\\n\\n"+
+			"نعتذر، ولكن تم رفض طلب موعدك. يمكنك طلب موعد آخر من خلال %s",
+			Constants.PublicBookingURL,
+			Constants.PublicBookingURL)
 
 		Whatsapp.SendMessage(appointmentReq.PhoneNumber, message)
 	}
@@ -349,9 +315,13 @@ func RejectAppointment(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Rejected Successfully"})
 }
 
+// MarkAppointmentAsCompleted marks one appointment, or with IDs several
+// attendees of a group session, as completed. Attendees that were already
+// completed are skipped so their package isn't charged twice.
 func MarkAppointmentAsCompleted(c *gin.Context) {
 	var input struct {
-		ID uint `json:"ID"`
+		ID  uint   `json:"ID"`
+		IDs []uint `json:"IDs"`
 	}
 	if err := c.ShouldBindJSON(&input); err != nil {
 		log.Println(err)
@@ -359,49 +329,63 @@ func MarkAppointmentAsCompleted(c *gin.Context) {
 		return
 	}
 
+	ids := input.IDs
+	if input.ID != 0 {
+		ids = append(ids, input.ID)
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No appointments provided"})
+		return
+	}
+
 	// Start a transaction
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback() // Rollback the transaction in case of panic
 		}
 	}()
 
-	var treatmentPlanID uint
+	for _, id := range ids {
+		var appointment Models.Appointment
 
-	if err := tx.Model(&Models.Appointment{}).Where("id = ?", input.ID).Select("treatment_plan_id").Find(&treatmentPlanID).Error; err != nil {
-		log.Println(err)
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
-		c.Abort()
-		return
-	}
+		if err := tx.Model(&Models.Appointment{}).Where("id = ?", id).First(&appointment).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, err)
+			c.Abort()
+			return
+		}
+		if appointment.IsCompleted {
+			continue
+		}
 
-	var TreatmentPlan Models.TreatmentPlan
+		var TreatmentPlan Models.TreatmentPlan
 
-	if err := tx.Model(&Models.TreatmentPlan{}).Where("id = ?", treatmentPlanID).First(&TreatmentPlan).Error; err != nil {
-		log.Println(err)
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
-		c.Abort()
-		return
-	}
+		if err := tx.Model(&Models.TreatmentPlan{}).Where("id = ?", appointment.TreatmentPlanID).First(&TreatmentPlan).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, err)
+			c.Abort()
+			return
+		}
 
-	TreatmentPlan.Remaining -= 1
+		TreatmentPlan.Remaining -= 1
 
-	if err := tx.Save(&TreatmentPlan).Error; err != nil {
-		log.Println(err)
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
-		c.Abort()
-		return
-	}
+		if err := tx.Save(&TreatmentPlan).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, err)
+			c.Abort()
+			return
+		}
 
-	if err := tx.Model(&Models.Appointment{}).Where("id = ?", input.ID).Update("is_completed", true).Error; err != nil {
-		log.Println(err)
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
-		return
+		if err := tx.Model(&Models.Appointment{}).Where("id = ?", id).Update("is_completed", true).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, err)
+			return
+		}
 	}
 
 	// Commit the transaction
@@ -428,7 +412,7 @@ func UnmarkAppointmentAsCompleted(c *gin.Context) {
 	}
 
 	// Start a transaction
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback() // Rollback the transaction in case of panic
@@ -483,9 +467,13 @@ func UnmarkAppointmentAsCompleted(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Marked Successfully"})
 }
 
+// RemoveAppointmentSendMessage deletes a time block with its appointments and
+// tells the patients. For a group session an appointment_id removes just that
+// attendee and keeps the session.
 func RemoveAppointmentSendMessage(c *gin.Context) {
 	var input struct {
-		ID uint `json:"ID"`
+		ID            uint `json:"ID"`
+		AppointmentID uint `json:"appointment_id"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -496,7 +484,7 @@ func RemoveAppointmentSendMessage(c *gin.Context) {
 	}
 
 	// Start a transaction
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback() // Rollback the transaction in case of panic
@@ -505,30 +493,62 @@ func RemoveAppointmentSendMessage(c *gin.Context) {
 
 	var TimeBlock Models.TimeBlock
 
-	if err := tx.Model(&Models.TimeBlock{}).Where("id = ?", input.ID).Preload("Appointment").First(&TimeBlock).Error; err != nil {
+	if err := tx.Model(&Models.TimeBlock{}).Where("id = ?", input.ID).Preload("Appointments").First(&TimeBlock).Error; err != nil {
 		log.Println(err)
 		tx.Rollback()
 		c.JSON(http.StatusBadRequest, err)
 		c.Abort()
 		return
 	}
-	var Patient Models.Patient
-	tx.Model(&Models.Patient{}).Where("id = ?", TimeBlock.Appointment.PatientID).First(&Patient)
 
-	if err := tx.Model(&Models.TimeBlock{}).Delete("id = ?", input.ID).Error; err != nil {
-		log.Println(err)
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
-		c.Abort()
-		return
+	removeAttendee := TimeBlock.IsGroup && input.AppointmentID != 0
+	appointments := TimeBlock.Appointments
+	if removeAttendee {
+		appointments = nil
+		for _, appointment := range TimeBlock.Appointments {
+			if appointment.ID == input.AppointmentID {
+				appointments = append(appointments, appointment)
+			}
+		}
+		if len(appointments) == 0 {
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Appointment not found in group session"})
+			return
+		}
 	}
 
-	if err := tx.Where("time_block_id = ?", input.ID).Delete(&Models.Appointment{}).Error; err != nil {
-		log.Println(err)
-		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
-		c.Abort()
-		return
+	var Patients []Models.Patient
+	for _, appointment := range appointments {
+		var Patient Models.Patient
+		if err := tx.Model(&Models.Patient{}).Where("id = ?", appointment.PatientID).First(&Patient).Error; err == nil {
+			Patients = append(Patients, Patient)
+		}
+	}
+
+	if removeAttendee {
+		if err := tx.Delete(&Models.Appointment{}, "id = ?", input.AppointmentID).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, err)
+			c.Abort()
+			return
+		}
+	} else {
+		if err := tx.Model(&Models.TimeBlock{}).Delete("id = ?", input.ID).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, err)
+			c.Abort()
+			return
+		}
+
+		if err := tx.Where("time_block_id = ?", input.ID).Delete(&Models.Appointment{}).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusBadRequest, err)
+			c.Abort()
+			return
+		}
 	}
 
 	// Commit the transaction
@@ -539,30 +559,174 @@ func RemoveAppointmentSendMessage(c *gin.Context) {
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Deleted Successfully"})
+	if !TimeBlock.IsGroup {
+		go offerFreedTimeBlock(TimeBlock)
+	}
 
-	if Patient.Phone != "" {
-		user_id, err := Token.ExtractTokenID(c)
-		if err != nil {
-			log.Println(err)
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		log.Println(err)
+	}
+	fcms, _ := Models.GetGroupFCMsByID(user_id)
+
+	for _, Patient := range Patients {
+		if Patient.Phone == "" {
+			continue
 		}
-		fcms, _ := Models.GetGroupFCMsByID(user_id)
 		if len(fcms) > 0 {
 			go FirebaseMessaging.SendMessage(Models.NotificationRequest{Tokens: fcms, Title: "Appointment Cancelled", Body: fmt.Sprintf("Your Appointment With %s, At %s Has Been Cancelled", Patient.Name, TimeBlock.DateTime)})
 		}
 
-		appointmentTime, err := time.Parse("2006/01/02 & 3:04 PM", TimeBlock.DateTime)
-		if appointmentTime.After(time.Now()) {
-			message := fmt.Sprintf("🚫 *APPOINTMENT DELETED* 🚫\\n\\n" +
-				"Dear Patient,\\n\\n" +
-				"We're sorry, but your appointment has been deleted. Please contact the clinic to reschedule at your earliest convenience.\\n\\n" +
-				"🚫 *تم إلغاء الموعد* 🚫\\n\\n" +
-				"عزيزي المريض،\\n\\n" +
-				"نعتذر، ولكن تم إلغاء موعدك. يرجى الاتصال بالعيادة لإعادة الحجز في أقرب وقت مناسب لك.")
+		if TimeBlock.DateTime.After(time.Now()) {
+			message := fmt.Sprintf("🚫 *APPOINTMENT DELETED* 🚫\\n\\n"+
+				"Dear Patient,\\n\\n"+
+				"We're sorry, but your appointment has been deleted. You can book a new one at %s\\n\\n"+
+				"🚫 *تم إلغاء الموعد* 🚫\\n\\n"+
+				"عزيزي المريض،\\n\\n"+
+				"نعتذر، ولكن تم إلغاء موعدك. يمكنك حجز موعد جديد من خلال %s",
+				Constants.PublicBookingURL,
+				Constants.PublicBookingURL)
 
 			go Whatsapp.SendMessage(Patient.Phone, message)
 		}
 	}
+}
+
+// moveAppointment books the slot at dateTime on the therapist's schedule,
+// releases the appointment's previous block and points the appointment at the
+// new one. Package association, completion and payment state are kept.
+func moveAppointment(tx *gorm.DB, appointment *Models.Appointment, therapist Models.Therapist, dateTime Models.DateTime) error {
+	schedule, err := findOrCreateSchedule(tx, therapist.ID)
+	if err != nil {
+		return err
+	}
+
+	// The old block is released first so it doesn't overlap the new slot
+	var durationMinutes uint
+	var requirement Models.ResourceRequirement
+	if appointment.TimeBlockID != 0 {
+		var previous Models.TimeBlock
+		if err := tx.First(&previous, appointment.TimeBlockID).Error; err == nil && !previous.IsGroup {
+			durationMinutes = previous.DurationMinutes
+			if requirement, err = Models.TimeBlockResources(tx, previous); err != nil {
+				return err
+			}
+		}
+		// A group session stays for its other attendees
+		if err := tx.Where("is_group = ?", false).Delete(&Models.TimeBlock{}, appointment.TimeBlockID).Error; err != nil {
+			return err
+		}
+	}
+
+	timeBlock, err := bookSession(tx, therapist, schedule, appointment.ClinicGroupID, Models.NewSlot(therapist, dateTime, durationMinutes), requirement)
+	if err != nil {
+		return err
+	}
+
+	appointment.TimeBlockID = timeBlock.ID
+	appointment.DateTime = dateTime
+	appointment.TherapistID = therapist.ID
+	appointment.TherapistName = therapist.Name
+	appointment.BranchID = therapist.BranchID
+	appointment.ReminderSent = dateTime.After(time.Now())
+
+	return tx.Model(appointment).
+		Select("time_block_id", "date_time", "therapist_id", "therapist_name", "branch_id", "reminder_sent").
+		Updates(appointment).Error
+}
+
+func RescheduleAppointment(c *gin.Context) {
+	var input struct {
+		AppointmentID uint            `json:"appointment_id" binding:"required"`
+		TherapistID   uint            `json:"therapist_id"`
+		DateTime      Models.DateTime `json:"date_time"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.DateTime.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Date time is required"})
+		return
+	}
+
+	// Start a transaction
+	tx := getScopedDB(c).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback() // Rollback the transaction in case of panic
+		}
+	}()
+
+	var appointment Models.Appointment
+	if err := tx.Model(&Models.Appointment{}).Where("id = ?", input.AppointmentID).First(&appointment).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return
+	}
+
+	if input.TherapistID == 0 {
+		input.TherapistID = appointment.TherapistID
+	}
+	input.DateTime = input.DateTime.WallClockIn(Models.ClinicGroupLocation(appointment.ClinicGroupID))
+
+	if input.TherapistID == appointment.TherapistID && input.DateTime.Equal(appointment.DateTime.Time) {
+		tx.Rollback()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Appointment is already at this time"})
+		return
+	}
+
+	var therapist Models.Therapist
+	if err := tx.Model(&Models.Therapist{}).Where("id = ?", input.TherapistID).First(&therapist).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	previousTherapistID, previousDateTime := appointment.TherapistID, appointment.DateTime
+	if err := moveAppointment(tx, &appointment, therapist, input.DateTime); err != nil {
+		tx.Rollback()
+		if message := bookingError(err); message != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reschedule appointment"})
+		return
+	}
+
+	// Commit the transaction
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment rescheduled successfully", "appointment": appointment})
+	SSE.Broadcaster.Broadcast("refresh")
+	go OfferFreedSlot(previousTherapistID, previousDateTime)
 
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		log.Println(err)
+	}
+	fcms, _ := Models.GetGroupFCMsByID(user_id)
+	if len(fcms) > 0 {
+		go FirebaseMessaging.SendMessage(Models.NotificationRequest{Tokens: fcms, Title: "Appointment Rescheduled", Body: fmt.Sprintf("%s's appointment has been moved to %s with %s", appointment.PatientName, appointment.DateTime, appointment.TherapistName)})
+	}
+
+	if appointment.DateTime.After(time.Now()) {
+		var patient Models.Patient
+		if err := getScopedDB(c).Model(&Models.Patient{}).Where("id = ?", appointment.PatientID).First(&patient).Error; err == nil && patient.Phone != "" {
+			go Whatsapp.SendMessage(patient.Phone, appointmentRescheduledMessage(appointment.DateTime, appointment.TherapistName, appointmentManageLink(appointment)))
+		}
+	}
 }
 
 func RemovePackage(c *gin.Context) {
@@ -575,7 +739,7 @@ func RemovePackage(c *gin.Context) {
 		c.Abort()
 		return
 	}
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback() // Rollback the transaction in case of panic
@@ -585,13 +749,13 @@ func RemovePackage(c *gin.Context) {
 	if err := tx.Model(&Models.TreatmentPlan{}).Preload("Appointments").Where("id = ?", &input.ID).First(&treatmentPlan).Error; err != nil {
 		log.Println(err)
 		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
 		c.Abort()
 		return
 	}
 
 	for _, appointment := range treatmentPlan.Appointments {
-		if err := tx.Delete(&Models.TimeBlock{}, "id = ?", appointment.TimeBlockID).Error; err != nil {
+		if err := tx.Delete(&Models.TimeBlock{}, "id = ? AND is_group = ?", appointment.TimeBlockID, false).Error; err != nil {
 			log.Println(err)
 			tx.Rollback()
 			c.JSON(http.StatusBadRequest, err)
@@ -624,6 +788,12 @@ func RemovePackage(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Package Deleted Successfully",
 	})
+
+	go func() {
+		for _, appointment := range treatmentPlan.Appointments {
+			OfferFreedSlot(appointment.TherapistID, appointment.DateTime)
+		}
+	}()
 }
 
 func DeletePatient(c *gin.Context) {
@@ -638,20 +808,26 @@ func DeletePatient(c *gin.Context) {
 	}
 
 	// Start a transaction
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback() // Rollback the transaction in case of panic
 		}
 	}()
 
-	if err := tx.Delete(&Models.Patient{}, "id = ?", input.PatientID).Error; err != nil {
-		log.Println(err)
+	result := tx.Delete(&Models.Patient{}, "id = ?", input.PatientID)
+	if result.Error != nil {
+		log.Println(result.Error)
 		tx.Rollback()
-		c.JSON(http.StatusBadRequest, err)
+		c.JSON(http.StatusBadRequest, result.Error)
 		c.Abort()
 		return
 	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
 
 	// Commit the transaction
 	if err := tx.Commit().Error; err != nil {
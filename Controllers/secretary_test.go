@@ -0,0 +1,68 @@
+package Controllers
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"PhysioUp/Models"
+)
+
+// Accepting several requests for the same slot at once books it only once.
+func TestAcceptAppointmentConcurrentSameSlot(t *testing.T) {
+	requireDatabase(t)
+	const attempts = 8
+
+	group := newClinicGroup(t)
+	therapist, schedule := newTherapist(t, group.ID)
+	// A past slot, so accepting it sends no WhatsApp confirmation
+	yesterday := time.Now().In(Models.DefaultLocation).AddDate(0, 0, -1)
+	slot := Models.NewDateTime(time.Date(yesterday.Year(), yesterday.Month(), yesterday.Day(), 10, 0, 0, 0, Models.DefaultLocation))
+
+	requestIDs := make([]uint, attempts)
+	for index := range requestIDs {
+		patient := newPatient(t, group.ID)
+		request := Models.AppointmentRequest{
+			DateTime:      slot,
+			TherapistID:   therapist.ID,
+			TherapistName: therapist.Name,
+			PatientID:     patient.ID,
+			PatientName:   patient.Name,
+			PhoneNumber:   patient.Phone,
+			ClinicGroupID: group.ID,
+		}
+		mustCreate(t, &request)
+		requestIDs[index] = request.ID
+	}
+
+	statuses := make([]int, attempts)
+	var wait sync.WaitGroup
+	start := make(chan struct{})
+	for index, requestID := range requestIDs {
+		wait.Add(1)
+		go func(index int, requestID uint) {
+			defer wait.Done()
+			<-start
+			statuses[index] = callHandler(AcceptAppointment, group.ID, map[string]interface{}{"appointment_request_id": requestID}).Code
+		}(index, requestID)
+	}
+	close(start)
+	wait.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != attempts-1 {
+		t.Fatalf("want 1 OK and %d conflicts, got statuses %v", attempts-1, statuses)
+	}
+
+	var booked int64
+	if err := Models.DB.Model(&Models.TimeBlock{}).Where("schedule_id = ? AND is_available = ?", schedule.ID, false).Count(&booked).Error; err != nil {
+		t.Fatal(err)
+	}
+	if booked != 1 {
+		t.Fatalf("want 1 booked time block, got %d", booked)
+	}
+}
@@ -0,0 +1,105 @@
+package Controllers
+
+import (
+	"log"
+	"net/http"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/Token"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FetchSessions lists the devices the caller is logged in on. last_used_at
+// is when the device last refreshed its access token.
+func FetchSessions(c *gin.Context) {
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sessionID, err := Token.ExtractSessionID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessions, err := Models.ActiveUserSessions(Models.DB, user_id)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load sessions"})
+		return
+	}
+
+	type sessionDTO struct {
+		Models.UserSession
+		Current       bool `json:"current"`
+		Notifications bool `json:"notifications"` // the device registered for notifications
+	}
+	output := make([]sessionDTO, 0, len(sessions))
+	for _, session := range sessions {
+		output = append(output, sessionDTO{
+			UserSession:   session,
+			Current:       session.ID == sessionID,
+			Notifications: session.DeviceToken != "",
+		})
+	}
+	c.JSON(http.StatusOK, output)
+}
+
+// RevokeSession signs one of the caller's devices out, revoking its refresh
+// token and its notification registration.
+func RevokeSession(c *gin.Context) {
+	var input struct {
+		SessionID uint `json:"session_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int64
+	if err := Models.DB.Model(&Models.UserSession{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", input.SessionID, user_id).Count(&count).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out device"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := Models.RevokeUserSession(Models.DB, input.SessionID); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out device"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Device Signed Out Successfully"})
+}
+
+// RevokeOtherSessions signs the caller out on every device but this one.
+func RevokeOtherSessions(c *gin.Context) {
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sessionID, err := Token.ExtractSessionID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := Models.RevokeOtherUserSessions(Models.DB, user_id, sessionID); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out devices"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Other Devices Signed Out Successfully"})
+}
@@ -0,0 +1,194 @@
+package Controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"PhysioUp/Models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clinicFixture is one clinic group's patient with a package, its booked
+// appointment, a waitlist entry, a referral, a therapist leave, a closure, a
+// room and a piece of equipment.
+type clinicFixture struct {
+	Group       Models.ClinicGroup
+	Patient     Models.Patient
+	Package     Models.TreatmentPlan
+	Appointment Models.Appointment
+	Referral    Models.Referral
+	Waitlist    Models.WaitlistEntry
+	Leave       Models.TherapistLeave
+	Closure     Models.ClinicClosure
+	Room        Models.Room
+	Equipment   Models.Equipment
+}
+
+func newClinicFixture(t *testing.T) clinicFixture {
+	t.Helper()
+	var fixture clinicFixture
+	fixture.Group = newClinicGroup(t)
+	fixture.Patient = newPatient(t, fixture.Group.ID)
+
+	superPlan := Models.SuperTreatmentPlan{Description: "6 Sessions", SessionsCount: 6, Price: 1200, ClinicGroupID: fixture.Group.ID}
+	mustCreate(t, &superPlan)
+	fixture.Referral = Models.Referral{Name: uniqueName("Referral"), CashbackPercentage: 10, ClinicGroupID: fixture.Group.ID}
+	mustCreate(t, &fixture.Referral)
+	fixture.Package = Models.TreatmentPlan{
+		SuperTreatmentPlanID: superPlan.ID,
+		Remaining:            5,
+		TotalPrice:           1200,
+		PatientID:            fixture.Patient.ID,
+		ClinicGroupID:        fixture.Group.ID,
+	}
+	mustCreate(t, &fixture.Package)
+
+	therapist, schedule := newTherapist(t, fixture.Group.ID)
+	timeBlock := Models.CreateEmptyTimeBlock(schedule, Models.NewDateTime(time.Now().Add(48*time.Hour).Truncate(time.Hour)))
+	mustCreate(t, &timeBlock)
+	fixture.Appointment = Models.Appointment{
+		DateTime:        timeBlock.DateTime,
+		TimeBlockID:     timeBlock.ID,
+		TherapistID:     therapist.ID,
+		TherapistName:   therapist.Name,
+		PatientID:       fixture.Patient.ID,
+		PatientName:     fixture.Patient.Name,
+		TreatmentPlanID: &fixture.Package.ID,
+		ClinicGroupID:   fixture.Group.ID,
+	}
+	mustCreate(t, &fixture.Appointment)
+
+	fixture.Waitlist = Models.WaitlistEntry{
+		PatientID:     fixture.Patient.ID,
+		PatientName:   fixture.Patient.Name,
+		PhoneNumber:   fixture.Patient.Phone,
+		Status:        Models.WaitlistWaiting,
+		QueuedAt:      time.Now(),
+		ClinicGroupID: fixture.Group.ID,
+	}
+	mustCreate(t, &fixture.Waitlist)
+
+	start := Models.NewDateTime(time.Now().AddDate(0, 0, 7).Truncate(time.Hour))
+	end := Models.NewDateTime(start.Add(24 * time.Hour))
+	fixture.Leave = Models.TherapistLeave{TherapistID: therapist.ID, StartsAt: start, EndsAt: end, ClinicGroupID: fixture.Group.ID}
+	mustCreate(t, &fixture.Leave)
+	fixture.Closure = Models.ClinicClosure{ClinicGroupID: fixture.Group.ID, StartsAt: start, EndsAt: end}
+	mustCreate(t, &fixture.Closure)
+
+	fixture.Room = Models.Room{Name: uniqueName("Room"), ClinicGroupID: fixture.Group.ID}
+	mustCreate(t, &fixture.Room)
+	fixture.Equipment = Models.Equipment{Name: uniqueName("Equipment"), Type: "ultrasound", ClinicGroupID: fixture.Group.ID}
+	mustCreate(t, &fixture.Equipment)
+	return fixture
+}
+
+// snapshot reads the fixture's rows as stored, deleted ones included, so
+// tests can tell whether anything changed.
+func (fixture clinicFixture) snapshot(t *testing.T) map[string][]map[string]interface{} {
+	t.Helper()
+	rows := map[string]uint{
+		"patients":         fixture.Patient.ID,
+		"treatment_plans":  fixture.Package.ID,
+		"appointments":     fixture.Appointment.ID,
+		"time_blocks":      fixture.Appointment.TimeBlockID,
+		"referrals":        fixture.Referral.ID,
+		"waitlist_entries": fixture.Waitlist.ID,
+		"therapist_leaves": fixture.Leave.ID,
+		"clinic_closures":  fixture.Closure.ID,
+		"rooms":            fixture.Room.ID,
+		"equipment":        fixture.Equipment.ID,
+	}
+	snapshot := map[string][]map[string]interface{}{}
+	for table, id := range rows {
+		var values []map[string]interface{}
+		if err := Models.DB.Raw("SELECT * FROM "+table+" WHERE id = ?", id).Scan(&values).Error; err != nil {
+			t.Fatalf("read %s: %v", table, err)
+		}
+		snapshot[table] = values
+	}
+	return snapshot
+}
+
+// Staff of one clinic group get 404 for another group's records and change
+// nothing.
+func TestCrossTenantAccessIsNotFound(t *testing.T) {
+	requireDatabase(t)
+	victim := newClinicFixture(t)
+	attacker := newClinicFixture(t)
+
+	cases := []struct {
+		name    string
+		handler gin.HandlerFunc
+		body    map[string]interface{}
+	}{
+		{"FetchPackageAppointments", FetchPackageAppointments, map[string]interface{}{"package_id": victim.Package.ID}},
+		{"MarkPackageAsPaid", MarkPackageAsPaid, map[string]interface{}{"package_id": victim.Package.ID, "payment_method": "cash"}},
+		{"UnMarkPackageAsPaid", UnMarkPackageAsPaid, map[string]interface{}{"package_id": victim.Package.ID}},
+		{"RemovePackage", RemovePackage, map[string]interface{}{"id": victim.Package.ID}},
+		{"SetPackageReferral", SetPackageReferral, map[string]interface{}{"package_id": victim.Package.ID, "discount": 100}},
+		{"SetPackageReferral with another clinic's referral", SetPackageReferral, map[string]interface{}{"package_id": attacker.Package.ID, "referral_id": victim.Referral.ID}},
+		{"DeletePatient", DeletePatient, map[string]interface{}{"patient_id": victim.Patient.ID}},
+		{"UpdatePatient", UpdatePatient, map[string]interface{}{"id": victim.Patient.ID, "name": "Changed", "phone": "+201111111111"}},
+		{"EditReferral", EditReferral, map[string]interface{}{"ID": victim.Referral.ID, "name": "Changed", "cashback_percentage": 90}},
+		{"DeleteReferral", DeleteReferral, map[string]interface{}{"referral_id": victim.Referral.ID}},
+		{"RemoveWaitlistEntry", RemoveWaitlistEntry, map[string]interface{}{"id": victim.Waitlist.ID}},
+		{"RemoveTherapistLeave", RemoveTherapistLeave, map[string]interface{}{"id": victim.Leave.ID}},
+		{"RemoveClinicClosure", RemoveClinicClosure, map[string]interface{}{"id": victim.Closure.ID}},
+		{"RemoveRoom", RemoveRoom, map[string]interface{}{"id": victim.Room.ID}},
+		{"RemoveEquipment", RemoveEquipment, map[string]interface{}{"id": victim.Equipment.ID}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			victimBefore, attackerBefore := victim.snapshot(t), attacker.snapshot(t)
+			recorder := callHandler(tc.handler, attacker.Group.ID, tc.body)
+			if recorder.Code != http.StatusNotFound {
+				t.Errorf("want 404, got %d: %s", recorder.Code, recorder.Body.String())
+			}
+			if after := victim.snapshot(t); !reflect.DeepEqual(victimBefore, after) {
+				t.Errorf("victim rows changed:\nbefore %v\nafter  %v", victimBefore, after)
+			}
+			if after := attacker.snapshot(t); !reflect.DeepEqual(attackerBefore, after) {
+				t.Errorf("attacker rows changed:\nbefore %v\nafter  %v", attackerBefore, after)
+			}
+		})
+	}
+
+	// The same requests work in the records' own clinic group
+	recorder := callHandler(FetchPackageAppointments, victim.Group.ID, map[string]interface{}{"package_id": victim.Package.ID})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("own package: want 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = callHandler(DeletePatient, victim.Group.ID, map[string]interface{}{"patient_id": victim.Patient.ID})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("own patient: want 200, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+// A request that reaches a handler without a clinic group is refused instead
+// of querying every clinic group's rows.
+func TestScopedDBWithoutClinicGroupFailsClosed(t *testing.T) {
+	requireDatabase(t)
+	victim := newClinicFixture(t)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+	var count int64
+	err := getScopedDB(c).Model(&Models.Patient{}).Where("id = ?", victim.Patient.ID).Count(&count).Error
+	if !errors.Is(err, errNoClinicGroup) {
+		t.Fatalf("want errNoClinicGroup, got %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("want no patients read, got %d", count)
+	}
+	if !c.IsAborted() || recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("want the request aborted with 500, got %d", recorder.Code)
+	}
+}
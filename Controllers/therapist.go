@@ -54,15 +54,17 @@ func GetTherapistSchedule(c *gin.Context) {
 	}
 
 	var therapist Models.Therapist
-	if err := Models.DB.Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	location := clinicLocation(c)
+
 	// Default to current month if dates are not provided
 	if input.StartDate == "" || input.EndDate == "" {
-		now := time.Now()
+		now := time.Now().In(location)
 		// First day of current month
 		firstDay := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
 		// Last day of current month
@@ -76,7 +78,7 @@ func GetTherapistSchedule(c *gin.Context) {
 
 	// #5 - Check if therapist has a schedule
 	var count int64
-	if err := Models.DB.Model(&Models.Schedule{}).Where("therapist_id = ?", therapist.ID).Count(&count).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.Schedule{}).Where("therapist_id = ?", therapist.ID).Count(&count).Error; err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check schedule existence"})
 		return
@@ -88,13 +90,13 @@ func GetTherapistSchedule(c *gin.Context) {
 			TherapistID: therapist.ID,
 			TimeBlocks:  []Models.TimeBlock{},
 		}
-		if err := Models.DB.Create(&schedule).Error; err != nil {
+		if err := getScopedDB(c).Create(&schedule).Error; err != nil {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule for therapist"})
 			return
 		}
 		// Reload therapist with new schedule
-		if err := Models.DB.Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
+		if err := getScopedDB(c).Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -102,7 +104,7 @@ func GetTherapistSchedule(c *gin.Context) {
 	}
 
 	// Fetch therapist with schedule
-	if err := Models.DB.Model(&Models.Therapist{}).Where("user_id = ?", user_id).Preload("Schedule").First(&therapist).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Where("user_id = ?", user_id).Preload("Schedule").First(&therapist).Error; err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -110,11 +112,15 @@ func GetTherapistSchedule(c *gin.Context) {
 
 	// #12 - Use consistent approach for handling soft deletes
 	// Using the built-in GORM soft delete handling instead of raw SQL
+	rangeStart, _ := time.ParseInLocation("2006/01/02", input.StartDate, location)
+	rangeEnd, _ := time.ParseInLocation("2006/01/02", input.EndDate, location)
+
 	var timeBlocks []Models.TimeBlock
-	if err := Models.DB.Model(&Models.TimeBlock{}).
+	if err := getScopedDB(c).Model(&Models.TimeBlock{}).
 		Where("schedule_id = ?", therapist.Schedule.ID).
-		Where("date_time >= ? AND date_time <= ?", input.StartDate, input.EndDate+" 23:59:59").
+		Where("date_time >= ? AND date_time < ?", rangeStart, rangeEnd.AddDate(0, 0, 1)).
 		Preload("Appointment").
+		Preload("Appointments").
 		Find(&timeBlocks).Error; err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -129,7 +135,8 @@ func GetTherapistSchedule(c *gin.Context) {
 
 func AddTherapistTimeBlocks(c *gin.Context) {
 	var input struct {
-		DateTimes []string `json:"date_times"`
+		DateTimes       []Models.DateTime `json:"date_times"`
+		DurationMinutes uint              `json:"duration_minutes"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -151,7 +158,7 @@ func AddTherapistTimeBlocks(c *gin.Context) {
 	}
 
 	var therapist Models.Therapist
-	if err := Models.DB.Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find therapist: " + err.Error()})
 		return
@@ -159,14 +166,14 @@ func AddTherapistTimeBlocks(c *gin.Context) {
 
 	// #5 - Check if therapist has a schedule and create one if not
 	var schedule Models.Schedule
-	if err := Models.DB.Where("therapist_id = ?", therapist.ID).First(&schedule).Error; err != nil {
+	if err := getScopedDB(c).Where("therapist_id = ?", therapist.ID).First(&schedule).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// Create a new schedule
 			schedule = Models.Schedule{
 				TherapistID: therapist.ID,
 				TimeBlocks:  []Models.TimeBlock{},
 			}
-			if err := Models.DB.Create(&schedule).Error; err != nil {
+			if err := getScopedDB(c).Create(&schedule).Error; err != nil {
 				log.Println(err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule: " + err.Error()})
 				return
@@ -179,35 +186,56 @@ func AddTherapistTimeBlocks(c *gin.Context) {
 	}
 
 	// Start a transaction to ensure consistency
-	tx := Models.DB.Begin()
+	tx := getScopedDB(c).Begin()
 
 	// List to store new time blocks
 	var newTimeBlocks []Models.TimeBlock
 
-	for _, dateTimeStr := range input.DateTimes {
+	location := clinicLocation(c)
+	for _, dateTime := range input.DateTimes {
+		dateTime = dateTime.WallClockIn(location)
+
+		// Blocked out time has no buffer of its own
+		slot := Models.NewSlot(therapist, dateTime, input.DurationMinutes)
+		slot.BufferMinutes = 0
+
 		// #3 - Check for time block overlap
 		var count int64
 		if err := tx.Model(&Models.TimeBlock{}).
-			Where("schedule_id = ? AND date_time = ?", schedule.ID, dateTimeStr).
+			Where("schedule_id = ? AND date_time = ?", schedule.ID, dateTime).
 			Count(&count).Error; err != nil {
 			tx.Rollback()
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check time block overlap: " + err.Error()})
 			return
 		}
+		booked, err := Models.IsSlotBooked(tx, schedule.ID, slot)
+		if err != nil {
+			tx.Rollback()
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check time block overlap: " + err.Error()})
+			return
+		}
 
-		if count > 0 {
+		if count > 0 || booked {
 			tx.Rollback()
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Time block already exists for %s", dateTimeStr),
+			c.JSON(http.StatusConflict, gin.H{
+				"error": fmt.Sprintf("Time block already exists for %s", dateTime),
 			})
 			return
 		}
 
 		// Create new time block
-		timeBlock := Models.CreateEmptyTimeBlock(schedule, dateTimeStr)
+		timeBlock := Models.CreateEmptyTimeBlock(schedule, dateTime)
+		timeBlock.DurationMinutes = slot.DurationMinutes
 		if err := tx.Create(&timeBlock).Error; err != nil {
 			tx.Rollback()
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				c.JSON(http.StatusConflict, gin.H{
+					"error": fmt.Sprintf("Time block already exists for %s", dateTime),
+				})
+				return
+			}
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create time block: " + err.Error()})
 			return
@@ -231,6 +259,11 @@ func AddTherapistTimeBlocks(c *gin.Context) {
 
 // TODO: Group public api
 func GetTherapists(c *gin.Context) {
+	branchID, ok := branchQuery(c)
+	if !ok {
+		return
+	}
+
 	user_id, _ := Token.ExtractTokenID(c)
 
 	client_group_id, err := Models.GetUserClinicGroupID(user_id)
@@ -238,11 +271,14 @@ func GetTherapists(c *gin.Context) {
 		log.Println(err)
 	}
 
-	query := Models.DB.Model(&Models.Therapist{}).Joins("JOIN users ON therapists.user_id = users.id").Preload("Schedule.TimeBlocks.Appointment")
+	query := getScopedDB(c).Model(&Models.Therapist{}).Joins("JOIN users ON therapists.user_id = users.id").Preload("Schedule.TimeBlocks.Appointment")
 
 	if client_group_id != 0 {
 		query = query.Where("users.clinic_group_id = ?", client_group_id)
 	}
+	if branchID != nil {
+		query = query.Where("therapists.branch_id = ?", *branchID)
+	}
 
 	var therapists []Models.Therapist
 	if err := query.Find(&therapists).Error; err != nil {
@@ -257,18 +293,19 @@ func GetTherapists(c *gin.Context) {
 // TODO: Group public api
 func GetTherapistsTrimmed(c *gin.Context) {
 
-	user_id, _ := Token.ExtractTokenID(c)
-
-	client_group_id, err := Models.GetUserClinicGroupID(user_id)
-	if err != nil {
-		log.Println(err)
+	client_group_id, ok := publicClinicGroupID(c)
+	if !ok {
+		return
 	}
 
 	// Define response structures without the gorm.Model fields
 	type TimeBlockDTO struct {
-		ID          uint   `json:"ID"`
-		DateTime    string `json:"date"`
-		IsAvailable bool   `json:"is_available"`
+		ID              uint            `json:"ID"`
+		DateTime        Models.DateTime `json:"date"`
+		IsAvailable     bool            `json:"is_available"`
+		DurationMinutes uint            `json:"duration_minutes"`
+		IsGroup         bool            `json:"is_group"`
+		SeatsLeft       uint            `json:"seats_left"`
 	}
 
 	type ScheduleDTO struct {
@@ -276,27 +313,32 @@ func GetTherapistsTrimmed(c *gin.Context) {
 		TimeBlocks []TimeBlockDTO `json:"time_blocks"`
 	}
 
+	type UnavailableDTO struct {
+		Start  Models.DateTime `json:"start"`
+		End    Models.DateTime `json:"end"`
+		Reason string          `json:"reason"`
+	}
+
 	type TherapistDTO struct {
-		ID       uint        `json:"ID"`
-		Name     string      `json:"name"`
-		UserID   uint        `json:"user_id"`
-		Phone    string      `json:"phone"`
-		Schedule ScheduleDTO `json:"schedule"`
-		PhotoUrl string      `json:"photo_url"`
-		IsDemo   bool        `json:"is_demo"`
-		IsFrozen bool        `json:"is_frozen"`
+		ID          uint             `json:"ID"`
+		Name        string           `json:"name"`
+		UserID      uint             `json:"user_id"`
+		Phone       string           `json:"phone"`
+		Schedule    ScheduleDTO      `json:"schedule"`
+		PhotoUrl    string           `json:"photo_url"`
+		IsDemo      bool             `json:"is_demo"`
+		IsFrozen    bool             `json:"is_frozen"`
+		Unavailable []UnavailableDTO `json:"unavailable"`
 	}
 
 	// Fetch data from database
 	var therapists []Models.Therapist
-	currentDate := time.Now().Format("2006/01/02")
+	currentDate := Models.Today(Models.ClinicGroupLocation(client_group_id))
 
 	query := Models.DB.Model(&Models.Therapist{}).Joins("JOIN users ON therapists.user_id = users.id").Preload("Schedule.TimeBlocks", "date_time >= ?", currentDate).
-		Preload("Schedule.TimeBlocks.Appointment")
+		Preload("Schedule.TimeBlocks.Appointment").
+		Preload("Schedule.TimeBlocks.Appointments")
 
-	if client_group_id == 0 {
-		client_group_id = 1
-	}
 	query = query.Where("users.clinic_group_id = ?", client_group_id)
 
 	if err := query.
@@ -306,9 +348,44 @@ func GetTherapistsTrimmed(c *gin.Context) {
 		return
 	}
 
+	// Leaves and closures hide the open slots they cover
+	var closures []Models.ClinicClosure
+	if err := Models.DB.Where("clinic_group_id = ? AND ends_at > ?", client_group_id, currentDate).Find(&closures).Error; err != nil {
+		log.Println(err)
+	}
+	var leaves []Models.TherapistLeave
+	if err := Models.DB.Where("clinic_group_id = ? AND ends_at > ?", client_group_id, currentDate).Find(&leaves).Error; err != nil {
+		log.Println(err)
+	}
+
 	// Convert to DTO without gorm.Model fields
 	var therapistDTOs []TherapistDTO
 	for _, therapist := range therapists {
+		unavailable := []UnavailableDTO{}
+		for _, closure := range closures {
+			unavailable = append(unavailable, UnavailableDTO{Start: closure.StartsAt, End: closure.EndsAt, Reason: closure.Reason})
+		}
+		var therapistLeaves []Models.TherapistLeave
+		for _, leave := range leaves {
+			if leave.TherapistID == therapist.ID {
+				therapistLeaves = append(therapistLeaves, leave)
+				unavailable = append(unavailable, UnavailableDTO{Start: leave.StartsAt, End: leave.EndsAt, Reason: leave.Reason})
+			}
+		}
+		isUnavailable := func(block Models.TimeBlock) bool {
+			for _, closure := range closures {
+				if closure.Overlaps(block.DateTime.Time, block.BusyUntil()) {
+					return true
+				}
+			}
+			for _, leave := range therapistLeaves {
+				if leave.Overlaps(block.DateTime.Time, block.BusyUntil()) {
+					return true
+				}
+			}
+			return false
+		}
+
 		// #5 - Handle missing schedule
 		if therapist.Schedule.ID == 0 {
 			// Create a default empty schedule object
@@ -324,6 +401,7 @@ func GetTherapistsTrimmed(c *gin.Context) {
 					ID:         0,
 					TimeBlocks: []TimeBlockDTO{},
 				},
+				Unavailable: unavailable,
 			}
 			therapistDTOs = append(therapistDTOs, therapistDTO)
 			continue
@@ -340,14 +418,35 @@ func GetTherapistsTrimmed(c *gin.Context) {
 			Schedule: ScheduleDTO{
 				ID: therapist.Schedule.ID,
 			},
+			Unavailable: unavailable,
+		}
+
+		// Open slots covered by a longer booked session are hidden as well
+		isOverlapped := func(open Models.TimeBlock) bool {
+			for _, booked := range therapist.Schedule.TimeBlocks {
+				if !booked.IsAvailable && booked.DateTime.Before(open.End()) && booked.BusyUntil().After(open.DateTime.Time) {
+					return true
+				}
+			}
+			return false
 		}
 
 		// Add time blocks
 		for _, block := range therapist.Schedule.TimeBlocks {
+			if block.IsAvailable && (isUnavailable(block) || isOverlapped(block)) {
+				continue
+			}
 			blockDTO := TimeBlockDTO{
-				ID:          block.ID,
-				DateTime:    block.DateTime,
-				IsAvailable: block.IsAvailable,
+				ID:              block.ID,
+				DateTime:        block.DateTime,
+				IsAvailable:     block.IsAvailable,
+				DurationMinutes: block.DurationMinutes,
+				IsGroup:         block.IsGroup,
+			}
+			// Group sessions stay bookable until every seat is taken
+			if block.IsGroup {
+				blockDTO.SeatsLeft = block.SeatsLeft()
+				blockDTO.IsAvailable = blockDTO.SeatsLeft > 0 && !isUnavailable(block)
 			}
 
 			therapistDTO.Schedule.TimeBlocks = append(therapistDTO.Schedule.TimeBlocks, blockDTO)
@@ -358,3 +457,36 @@ func GetTherapistsTrimmed(c *gin.Context) {
 
 	c.JSON(http.StatusOK, therapistDTOs)
 }
+
+func UpdateTherapistSessionSettings(c *gin.Context) {
+	var input struct {
+		TherapistID    uint `json:"therapist_id" binding:"required"`
+		SessionMinutes uint `json:"session_minutes" binding:"required"`
+		BufferMinutes  uint `json:"buffer_minutes"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	if input.SessionMinutes > 8*60 || input.BufferMinutes > 2*60 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Session can't exceed 8 hours and buffer can't exceed 2 hours"})
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+	if _, err := findClinicTherapist(input.TherapistID, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Where("id = ?", input.TherapistID).
+		Updates(map[string]interface{}{"session_minutes": input.SessionMinutes, "buffer_minutes": input.BufferMinutes}).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update therapist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Therapist Updated Successfully"})
+}
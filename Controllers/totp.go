@@ -0,0 +1,271 @@
+package Controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/Token"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// challengeUser returns the user of a login token handed out by Login when
+// a second step is required.
+func challengeUser(c *gin.Context, loginToken string) (Models.User, bool) {
+	user_id, err := Token.ParseLoginChallengeToken(loginToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, log in again"})
+		return Models.User{}, false
+	}
+	user, err := Models.GetUserByID(user_id)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login expired, log in again"})
+		return Models.User{}, false
+	}
+	if user.IsFrozen {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "User Frozen"})
+		return Models.User{}, false
+	}
+	return user, true
+}
+
+func respondTOTPError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, Models.ErrTOTPInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Incorrect authentication code"})
+	case errors.Is(err, Models.ErrTOTPNotPending):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start two-factor setup first"})
+	case errors.Is(err, Models.ErrTOTPAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+	default:
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update two-factor authentication"})
+	}
+}
+
+func startSession(c *gin.Context, user Models.User, extra gin.H) {
+	session, refreshToken, err := Models.CreateUserSession(Models.DB, user.ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	recordLoginAttempt(c, user.Username, true, "")
+	respondWithSessionExtra(c, user, session, refreshToken, "Login Successful", extra)
+}
+
+// VerifyLoginTOTP finishes a login with a code from the user's authenticator
+// app or one of their recovery codes.
+func VerifyLoginTOTP(c *gin.Context) {
+	var input struct {
+		LoginToken string `json:"login_token" binding:"required"`
+		Code       string `json:"code" binding:"required"` // app or recovery code
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := challengeUser(c, input.LoginToken)
+	if !ok || loginThrottled(c, user.Username) {
+		return
+	}
+	if err := Models.VerifyUserTOTP(Models.DB, user.ID, input.Code); err != nil {
+		if errors.Is(err, Models.ErrTOTPInvalid) {
+			recordLoginAttempt(c, user.Username, false, Models.LoginFailedTOTP)
+		}
+		respondTOTPError(c, err)
+		return
+	}
+	startSession(c, user, nil)
+}
+
+// BeginLoginTOTPEnrolment starts two-factor setup during login for users of
+// clinic groups that require it.
+func BeginLoginTOTPEnrolment(c *gin.Context) {
+	var input struct {
+		LoginToken string `json:"login_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := challengeUser(c, input.LoginToken)
+	if !ok {
+		return
+	}
+	secret, uri, err := Models.BeginTOTPEnrolment(Models.DB, user)
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "uri": uri})
+}
+
+// ConfirmLoginTOTPEnrolment enables two-factor authentication and logs the
+// user in. The recovery codes are only returned here.
+func ConfirmLoginTOTPEnrolment(c *gin.Context) {
+	var input struct {
+		LoginToken string `json:"login_token" binding:"required"`
+		Code       string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := challengeUser(c, input.LoginToken)
+	if !ok || loginThrottled(c, user.Username) {
+		return
+	}
+	recoveryCodes, err := Models.ConfirmTOTPEnrolment(Models.DB, user.ID, input.Code)
+	if err != nil {
+		if errors.Is(err, Models.ErrTOTPInvalid) {
+			recordLoginAttempt(c, user.Username, false, Models.LoginFailedTOTP)
+		}
+		respondTOTPError(c, err)
+		return
+	}
+	user.TOTPEnabled = true
+	startSession(c, user, gin.H{"recovery_codes": recoveryCodes})
+}
+
+// EnrolTOTP starts two-factor setup for the logged in user. It returns the
+// secret and an otpauth URI to show as a QR code.
+func EnrolTOTP(c *gin.Context) {
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := Models.GetUserByID(user_id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	secret, uri, err := Models.BeginTOTPEnrolment(Models.DB, user)
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "uri": uri})
+}
+
+// ConfirmTOTP enables two-factor authentication once the user enters a code
+// from their app. The recovery codes are only returned here.
+func ConfirmTOTP(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryCodes, err := Models.ConfirmTOTPEnrolment(Models.DB, user_id, input.Code)
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-Factor Authentication Enabled", "recovery_codes": recoveryCodes})
+}
+
+// RegenerateRecoveryCodes replaces the logged in user's recovery codes after
+// checking a current code.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := Models.VerifyUserTOTP(Models.DB, user_id, input.Code); err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	recoveryCodes, err := Models.RegenerateRecoveryCodes(Models.DB, user_id)
+	if err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+// DisableTOTP turns two-factor authentication off for the logged in user,
+// unless their clinic group requires it.
+func DisableTOTP(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	var group Models.ClinicGroup
+	if err := getScopedDB(c).Select("id", "require_totp").First(&group, clinicGroupID).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load clinic group"})
+		return
+	}
+	if group.RequireTOTP {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your clinic requires two-factor authentication"})
+		return
+	}
+
+	if err := Models.VerifyUserTOTP(Models.DB, user_id, input.Code); err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	if err := Models.ResetTOTP(Models.DB, user_id); err != nil {
+		respondTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-Factor Authentication Disabled"})
+}
+
+// ResetUserTOTP turns two-factor authentication off for a user of the
+// caller's clinic group who lost their phone and recovery codes. If the group
+// requires it they set it up again on their next login.
+func ResetUserTOTP(c *gin.Context) {
+	var input struct {
+		UserID uint `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := Models.ResetTOTP(getScopedDB(c), input.UserID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		respondTOTPError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Two-Factor Authentication Reset"})
+}
@@ -0,0 +1,50 @@
+package Controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/TOTP"
+)
+
+// An app code logs in once, and a second time is refused even while it is
+// still inside the skew window.
+func TestVerifyUserTOTPRefusesAReusedCode(t *testing.T) {
+	requireDatabase(t)
+	group := newClinicGroup(t)
+	secret, err := TOTP.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := Models.User{
+		Username:      uniqueName("User"),
+		Password:      "password123",
+		Role:          Models.RoleOwner,
+		ClinicGroupID: group.ID,
+		TOTPEnabled:   true,
+		TOTPSecret:    secret,
+	}
+	mustCreate(t, &user)
+
+	code, err := TOTP.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Models.VerifyUserTOTP(Models.DB, user.ID, code); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := Models.VerifyUserTOTP(Models.DB, user.ID, code); !errors.Is(err, Models.ErrTOTPInvalid) {
+		t.Fatalf("reuse: want ErrTOTPInvalid, got %v", err)
+	}
+
+	// An earlier step inside the window is refused once a later one was used
+	previous, err := TOTP.Code(secret, time.Now().Add(-TOTP.Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Models.VerifyUserTOTP(Models.DB, user.ID, previous); !errors.Is(err, Models.ErrTOTPInvalid) {
+		t.Fatalf("earlier step: want ErrTOTPInvalid, got %v", err)
+	}
+}
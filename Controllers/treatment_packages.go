@@ -44,7 +44,7 @@ func AddSuperTreatment(c *gin.Context) {
 
 	input.ClinicGroupID = client_group_id.(uint)
 
-	if err := Models.DB.Create(&input).Error; err != nil {
+	if err := getScopedDB(c).Create(&input).Error; err != nil {
 		// #4 - More specific error handling
 		if strings.Contains(err.Error(), "foreign key constraint") {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Foreign key constraint failed. Please ensure all referenced entities exist."})
@@ -74,7 +74,7 @@ func EditSuperTreatment(c *gin.Context) {
 
 	// #10 - Check if package exists
 	var existingPlan Models.SuperTreatmentPlan
-	if err := Models.DB.First(&existingPlan, input.ID).Error; err != nil {
+	if err := getScopedDB(c).First(&existingPlan, input.ID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Package with ID %d not found", input.ID)})
 		} else {
@@ -86,7 +86,7 @@ func EditSuperTreatment(c *gin.Context) {
 	// #8 - Handle referential integrity for TreatmentPlans
 	// Get existing treatment plans
 	var existingTreatmentPlans []Models.TreatmentPlan
-	if err := Models.DB.Where("super_treatment_plan_id = ?", input.ID).Find(&existingTreatmentPlans).Error; err != nil {
+	if err := getScopedDB(c).Where("super_treatment_plan_id = ?", input.ID).Find(&existingTreatmentPlans).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve associated treatment plans: " + err.Error()})
 		return
 	}
@@ -94,7 +94,7 @@ func EditSuperTreatment(c *gin.Context) {
 	// Check if changing sessions count would affect existing treatment plans
 	if existingPlan.SessionsCount != input.SessionsCount && len(existingTreatmentPlans) > 0 {
 		// Option 1: Update existing treatment plans (done in a transaction)
-		tx := Models.DB.Begin()
+		tx := getScopedDB(c).Begin()
 		for _, plan := range existingTreatmentPlans {
 			if plan.Remaining > input.SessionsCount {
 				plan.Remaining = input.SessionsCount
@@ -109,7 +109,7 @@ func EditSuperTreatment(c *gin.Context) {
 	}
 
 	// Update the super treatment plan
-	if err := Models.DB.Save(&input).Error; err != nil {
+	if err := getScopedDB(c).Save(&input).Error; err != nil {
 		// #4 - More specific error handling
 		if strings.Contains(err.Error(), "foreign key constraint") {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Foreign key constraint failed. Please ensure all referenced entities exist."})
@@ -135,7 +135,7 @@ func DeleteSuperTreatment(c *gin.Context) {
 
 	// #10 - Check if package exists before deleting
 	var existingPlan Models.SuperTreatmentPlan
-	if err := Models.DB.First(&existingPlan, input.PackageID).Error; err != nil {
+	if err := getScopedDB(c).First(&existingPlan, input.PackageID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Package with ID %d not found", input.PackageID)})
 		} else {
@@ -146,7 +146,7 @@ func DeleteSuperTreatment(c *gin.Context) {
 
 	// #5 - Check for foreign key constraints (related TreatmentPlans)
 	var count int64
-	Models.DB.Model(&Models.TreatmentPlan{}).Where("super_treatment_plan_id = ?", input.PackageID).Count(&count)
+	getScopedDB(c).Model(&Models.TreatmentPlan{}).Where("super_treatment_plan_id = ?", input.PackageID).Count(&count)
 	if count > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": fmt.Sprintf("Cannot delete package with ID %d. It has %d associated treatment plans.", input.PackageID, count),
@@ -154,7 +154,7 @@ func DeleteSuperTreatment(c *gin.Context) {
 		return
 	}
 
-	if err := Models.DB.Delete(&Models.SuperTreatmentPlan{}, "id = ?", input.PackageID).Error; err != nil {
+	if err := getScopedDB(c).Delete(&Models.SuperTreatmentPlan{}, "id = ?", input.PackageID).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to delete package: " + err.Error()})
 		return
 	}
@@ -182,5 +182,11 @@ func validateSuperTreatmentPlan(plan *Models.SuperTreatmentPlan) error {
 		return errors.New("price cannot be negative")
 	}
 
+	if plan.SessionMinutes > 8*60 {
+		return errors.New("session length can't exceed 8 hours")
+	}
+
+	plan.RequiredEquipmentType = strings.ToLower(strings.TrimSpace(plan.RequiredEquipmentType))
+
 	return nil
 }
@@ -0,0 +1,264 @@
+package Controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"PhysioUp/Models"
+	"PhysioUp/SSE"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unavailabilityError maps the leave and closure errors of Models.CheckBookable
+// and the opening hours error of Models.CheckBranchHours to a message, or
+// returns "" for other errors.
+func unavailabilityError(err error) string {
+	switch {
+	case errors.Is(err, Models.ErrClinicClosed):
+		return "Clinic is closed at this time"
+	case errors.Is(err, Models.ErrTherapistOnLeave):
+		return "Therapist is on leave at this time"
+	case errors.Is(err, Models.ErrBranchClosed):
+		return "Branch is closed at this time"
+	}
+	return ""
+}
+
+func findClinicTherapist(therapistID uint, clinicGroupID uint) (Models.Therapist, error) {
+	var therapist Models.Therapist
+	err := Models.DB.Model(&Models.Therapist{}).
+		Joins("JOIN users ON therapists.user_id = users.id").
+		Where("therapists.id = ? AND users.clinic_group_id = ?", therapistID, clinicGroupID).
+		First(&therapist).Error
+	return therapist, err
+}
+
+func FetchTherapistLeaves(c *gin.Context) {
+	db := getScopedDB(c)
+	query := db.Model(&Models.TherapistLeave{}).Where("ends_at > ?", time.Now())
+	if therapistID := c.Query("therapist_id"); therapistID != "" {
+		query = query.Where("therapist_id = ?", therapistID)
+	}
+
+	var leaves []Models.TherapistLeave
+	if err := query.Order("starts_at").Find(&leaves).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, leaves)
+}
+
+// AddTherapistLeave records a leave period and returns the appointments that
+// are already booked inside it so they can be rescheduled.
+func AddTherapistLeave(c *gin.Context) {
+	var input struct {
+		TherapistID uint            `json:"therapist_id" binding:"required"`
+		Start       Models.DateTime `json:"start"`
+		End         Models.DateTime `json:"end"`
+		Reason      string          `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+
+	location := clinicLocation(c)
+	start := input.Start.WallClockIn(location)
+	end := input.End.WallClockIn(location)
+	if start.IsZero() || end.IsZero() || !end.After(start.Time) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Leave needs a start before its end"})
+		return
+	}
+
+	if _, err := findClinicTherapist(input.TherapistID, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	leave := Models.TherapistLeave{
+		TherapistID:   input.TherapistID,
+		StartsAt:      start,
+		EndsAt:        end,
+		Reason:        input.Reason,
+		ClinicGroupID: id,
+	}
+	if err := getScopedDB(c).Create(&leave).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add leave"})
+		return
+	}
+
+	collisions, err := Models.LeaveCollisions(getScopedDB(c), leave)
+	if err != nil {
+		log.Println(err)
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Leave Added Successfully", "leave": leave, "collisions": collisions})
+}
+
+func RemoveTherapistLeave(c *gin.Context) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := getScopedDB(c).Delete(&Models.TherapistLeave{}, input.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Leave not found"})
+		return
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Leave Removed Successfully"})
+}
+
+func FetchClinicClosures(c *gin.Context) {
+	db := getScopedDB(c)
+	var closures []Models.ClinicClosure
+	if err := db.Model(&Models.ClinicClosure{}).Where("ends_at > ?", time.Now()).Order("starts_at").Find(&closures).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, closures)
+}
+
+// AddClinicClosure closes the clinic for whole days, from the start of
+// start_date to the end of end_date, and returns the colliding appointments.
+func AddClinicClosure(c *gin.Context) {
+	var input struct {
+		StartDate string `json:"start_date" binding:"required"`
+		EndDate   string `json:"end_date"`
+		Reason    string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.EndDate == "" {
+		input.EndDate = input.StartDate
+	}
+
+	location := clinicLocation(c)
+	startDate, err := time.ParseInLocation("2006/01/02", input.StartDate, location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format. Use YYYY/MM/DD"})
+		return
+	}
+	endDate, err := time.ParseInLocation("2006/01/02", input.EndDate, location)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format. Use YYYY/MM/DD"})
+		return
+	}
+	if startDate.After(endDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start date must be before end date"})
+		return
+	}
+
+	clinicGroupID, _ := c.Get("clinicGroupID")
+	id, _ := clinicGroupID.(uint)
+
+	closure := Models.ClinicClosure{
+		ClinicGroupID: id,
+		StartsAt:      Models.DateTime{Time: startDate},
+		EndsAt:        Models.DateTime{Time: endDate.AddDate(0, 0, 1)},
+		Reason:        input.Reason,
+	}
+	if err := getScopedDB(c).Create(&closure).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add closure"})
+		return
+	}
+
+	collisions, err := Models.ClosureCollisions(getScopedDB(c), closure)
+	if err != nil {
+		log.Println(err)
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Closure Added Successfully", "closure": closure, "collisions": collisions})
+}
+
+func RemoveClinicClosure(c *gin.Context) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := getScopedDB(c).Delete(&Models.ClinicClosure{}, input.ID)
+	if result.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Closure not found"})
+		return
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Closure Removed Successfully"})
+}
+
+// FetchUnavailabilityCollisions lists every upcoming appointment that falls
+// inside a leave or closure of the caller's clinic group.
+func FetchUnavailabilityCollisions(c *gin.Context) {
+	now := time.Now()
+
+	var leaves []Models.TherapistLeave
+	if err := getScopedDB(c).Model(&Models.TherapistLeave{}).Where("ends_at > ?", now).Order("starts_at").Find(&leaves).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var closures []Models.ClinicClosure
+	if err := getScopedDB(c).Model(&Models.ClinicClosure{}).Where("ends_at > ?", now).Order("starts_at").Find(&closures).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	type Collision struct {
+		Reason       string               `json:"reason"`
+		LeaveID      uint                 `json:"leave_id,omitempty"`
+		ClosureID    uint                 `json:"closure_id,omitempty"`
+		Appointments []Models.Appointment `json:"appointments"`
+	}
+
+	collisions := []Collision{}
+	for _, leave := range leaves {
+		appointments, err := Models.LeaveCollisions(getScopedDB(c), leave)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if len(appointments) > 0 {
+			collisions = append(collisions, Collision{Reason: leave.Reason, LeaveID: leave.ID, Appointments: appointments})
+		}
+	}
+	for _, closure := range closures {
+		appointments, err := Models.ClosureCollisions(getScopedDB(c), closure)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if len(appointments) > 0 {
+			collisions = append(collisions, Collision{Reason: closure.Reason, ClosureID: closure.ID, Appointments: appointments})
+		}
+	}
+
+	c.JSON(http.StatusOK, collisions)
+}
@@ -0,0 +1,418 @@
+package Controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"PhysioUp/Constants"
+	"PhysioUp/FirebaseMessaging"
+	"PhysioUp/Models"
+	"PhysioUp/SSE"
+	"PhysioUp/Whatsapp"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Number of minutes a waitlisted patient has to claim an offered slot
+const waitlistClaimMinutes = 30
+
+type waitlistWindowInput struct {
+	Start Models.DateTime `json:"start"`
+	End   Models.DateTime `json:"end"`
+}
+
+func buildWaitlistWindows(inputs []waitlistWindowInput, location *time.Location) ([]Models.WaitlistWindow, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("at least one preferred window is required")
+	}
+	var windows []Models.WaitlistWindow
+	for _, input := range inputs {
+		start := input.Start.WallClockIn(location)
+		end := input.End.WallClockIn(location)
+		if start.IsZero() || end.IsZero() {
+			return nil, errors.New("each window needs a start and an end")
+		}
+		if !end.After(start.Time) {
+			return nil, errors.New("window end must be after its start")
+		}
+		if end.Before(time.Now()) {
+			return nil, errors.New("windows must end in the future")
+		}
+		windows = append(windows, Models.WaitlistWindow{StartsAt: start, EndsAt: end})
+	}
+	return windows, nil
+}
+
+func FetchWaitlist(c *gin.Context) {
+	db := getScopedDB(c)
+	var entries []Models.WaitlistEntry
+	if err := db.Model(&Models.WaitlistEntry{}).
+		Where("status IN ?", []string{Models.WaitlistWaiting, Models.WaitlistOffered}).
+		Preload("Windows").
+		Order("queued_at").
+		Find(&entries).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func AddWaitlistEntry(c *gin.Context) {
+	var input struct {
+		PatientID   uint                  `json:"patient_id" binding:"required"`
+		TherapistID *uint                 `json:"therapist_id"`
+		Windows     []waitlistWindowInput `json:"windows"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	client_group_id, exists := c.Get("clinicGroupID")
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: Client Group Not Set"})
+		return
+	}
+
+	windows, err := buildWaitlistWindows(input.Windows, clinicLocation(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var patient Models.Patient
+	if err := getScopedDB(c).Model(&Models.Patient{}).Where("id = ?", input.PatientID).First(&patient).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
+
+	entry := Models.WaitlistEntry{
+		PatientID:     patient.ID,
+		PatientName:   patient.Name,
+		PhoneNumber:   patient.Phone,
+		TherapistID:   input.TherapistID,
+		Windows:       windows,
+		Status:        Models.WaitlistWaiting,
+		QueuedAt:      time.Now(),
+		ClinicGroupID: client_group_id.(uint),
+	}
+	if err := getScopedDB(c).Create(&entry).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add waitlist entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Added To Waitlist Successfully", "id": entry.ID})
+}
+
+func RemoveWaitlistEntry(c *gin.Context) {
+	var input struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := getScopedDB(c).Model(&Models.WaitlistEntry{}).Where("id = ?", input.ID).
+		Updates(map[string]interface{}{"status": Models.WaitlistCancelled, "offer_token_hash": ""})
+	if result.Error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Waitlist entry not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Removed From Waitlist Successfully"})
+}
+
+// JoinWaitlist lets patients logged in to the portal wait for a slot when
+// the therapist they want is fully booked. Offers go to the phone number they
+// verified when logging in.
+func JoinWaitlist(c *gin.Context) {
+	var input struct {
+		TherapistID *uint                 `json:"therapist_id"`
+		Windows     []waitlistWindowInput `json:"windows"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var patient Models.Patient
+	if err := Models.DB.First(&patient, authenticatedPatientID(c)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
+	// On the /c/:slug routes the patient has to belong to that clinic
+	if clinicGroupID, exists := c.Get("clinicGroupID"); exists && clinicGroupID != patient.ClinicGroupID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Patient not found"})
+		return
+	}
+
+	if input.TherapistID != nil {
+		if _, err := findClinicTherapist(*input.TherapistID, patient.ClinicGroupID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+			return
+		}
+	}
+
+	windows, err := buildWaitlistWindows(input.Windows, Models.ClinicGroupLocation(patient.ClinicGroupID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry := Models.WaitlistEntry{
+		PatientID:     patient.ID,
+		PatientName:   patient.Name,
+		PhoneNumber:   patient.Phone,
+		TherapistID:   input.TherapistID,
+		Windows:       windows,
+		Status:        Models.WaitlistWaiting,
+		QueuedAt:      time.Now(),
+		ClinicGroupID: patient.ClinicGroupID,
+	}
+	if err := Models.DB.Create(&entry).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to join waitlist"})
+		return
+	}
+
+	SSE.Broadcaster.Broadcast("refresh")
+	c.JSON(http.StatusOK, gin.H{"message": "Joined Waitlist Successfully", "id": entry.ID})
+}
+
+// ClaimWaitlistOffer books the slot offered to a waitlisted patient if the
+// claim link is still valid.
+func ClaimWaitlistOffer(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var entry Models.WaitlistEntry
+	if err := Models.DB.Model(&Models.WaitlistEntry{}).
+		Where("offer_token_hash = ? AND status = ?", Models.HashToken(input.Token), Models.WaitlistOffered).
+		First(&entry).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+
+	if entry.OfferExpiresAt == nil || entry.OfferExpiresAt.Before(time.Now()) || entry.OfferedTherapistID == nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Offer expired"})
+		return
+	}
+
+	// Claims are made by patients, so they are audited without an actor
+	db := auditedScopedDB(c, entry.ClinicGroupID, 0)
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var therapist Models.Therapist
+	if err := tx.Model(&Models.Therapist{}).Where("id = ?", *entry.OfferedTherapistID).First(&therapist).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	schedule, err := findOrCreateSchedule(tx, therapist.ID)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load therapist schedule"})
+		return
+	}
+
+	timeBlock, err := bookSession(tx, therapist, schedule, entry.ClinicGroupID, Models.NewSlot(therapist, entry.OfferedDateTime, 0), Models.ResourceRequirement{})
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, Models.ErrTimeBlockTaken) {
+			db.Model(&Models.WaitlistEntry{}).Where("id = ?", entry.ID).
+				Updates(map[string]interface{}{"status": Models.WaitlistWaiting, "offer_token_hash": ""})
+		}
+		if message := bookingError(err); message != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": message})
+			return
+		}
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to book time block"})
+		return
+	}
+
+	appointment := Models.Appointment{
+		DateTime:      entry.OfferedDateTime,
+		TimeBlockID:   timeBlock.ID,
+		TherapistID:   therapist.ID,
+		TherapistName: therapist.Name,
+		PatientID:     entry.PatientID,
+		PatientName:   entry.PatientName,
+		ReminderSent:  entry.OfferedDateTime.After(time.Now()),
+		ClinicGroupID: entry.ClinicGroupID,
+	}
+	if err := tx.Create(&appointment).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create appointment"})
+		return
+	}
+
+	result := tx.Model(&Models.WaitlistEntry{}).
+		Where("id = ? AND status = ?", entry.ID, Models.WaitlistOffered).
+		Updates(map[string]interface{}{"status": Models.WaitlistClaimed, "offer_token_hash": ""})
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Offer already claimed"})
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Appointment Booked Successfully", "date_time": appointment.DateTime})
+	SSE.Broadcaster.Broadcast("refresh")
+
+	fcms, _ := Models.GetGroupFCMsByID(therapist.UserID)
+	if len(fcms) > 0 {
+		go FirebaseMessaging.SendMessage(Models.NotificationRequest{Tokens: fcms, Title: "Waitlist Slot Claimed", Body: fmt.Sprintf("%s claimed the slot at %s with %s", entry.PatientName, appointment.DateTime, therapist.Name)})
+	}
+	go Whatsapp.SendMessage(entry.PhoneNumber, appointmentConfirmationMessage(appointment.DateTime, therapist.Name, appointmentManageLink(appointment)))
+}
+
+// OfferFreedSlot offers a slot that has just been freed to the next patient
+// on the waitlist whose preferred windows contain it.
+func OfferFreedSlot(therapistID uint, dateTime Models.DateTime) {
+	offerFreedSlot(therapistID, dateTime, 0)
+}
+
+// offerFreedTimeBlock resolves the therapist of a released time block
+func offerFreedTimeBlock(timeBlock Models.TimeBlock) {
+	var therapistID uint
+	if err := Models.DB.Model(&Models.Schedule{}).Where("id = ?", timeBlock.ScheduleID).Select("therapist_id").Scan(&therapistID).Error; err != nil || therapistID == 0 {
+		return
+	}
+	OfferFreedSlot(therapistID, timeBlock.DateTime)
+}
+
+func offerFreedSlot(therapistID uint, dateTime Models.DateTime, excludeEntryID uint) {
+	if dateTime.IsZero() || !dateTime.After(time.Now()) {
+		return
+	}
+
+	var therapist Models.Therapist
+	if err := Models.DB.Model(&Models.Therapist{}).Where("id = ?", therapistID).Preload("Schedule").First(&therapist).Error; err != nil {
+		log.Printf("Waitlist: therapist %d not found: %v", therapistID, err)
+		return
+	}
+	clinicGroupID, err := Models.GetUserClinicGroupID(therapist.UserID)
+	if err != nil {
+		log.Printf("Waitlist: %v", err)
+		return
+	}
+
+	slot := Models.NewSlot(therapist, dateTime, 0)
+	if err := Models.CheckBookable(Models.DB, therapistID, clinicGroupID, slot); err != nil {
+		return
+	}
+
+	if therapist.Schedule.ID != 0 {
+		booked, err := Models.IsSlotBooked(Models.DB, therapist.Schedule.ID, slot)
+		if err != nil || booked {
+			return
+		}
+	}
+
+	// Only one open offer per slot
+	var pending int64
+	if err := Models.DB.Model(&Models.WaitlistEntry{}).
+		Where("status = ? AND offered_therapist_id = ? AND offered_date_time = ?", Models.WaitlistOffered, therapistID, dateTime).
+		Count(&pending).Error; err != nil || pending > 0 {
+		return
+	}
+
+	query := Models.DB.Model(&Models.WaitlistEntry{}).
+		Where("clinic_group_id = ? AND status = ?", clinicGroupID, Models.WaitlistWaiting).
+		Where("therapist_id IS NULL OR therapist_id = ?", therapistID).
+		Where("EXISTS (SELECT 1 FROM waitlist_windows WHERE waitlist_windows.waitlist_entry_id = waitlist_entries.id AND waitlist_windows.deleted_at IS NULL AND waitlist_windows.starts_at <= ? AND waitlist_windows.ends_at >= ?)", dateTime, dateTime)
+	if excludeEntryID != 0 {
+		query = query.Where("id <> ?", excludeEntryID)
+	}
+
+	var entry Models.WaitlistEntry
+	if err := query.Order("queued_at").First(&entry).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Waitlist: failed to find next entry: %v", err)
+		}
+		return
+	}
+
+	token, hash, err := Models.GenerateSecureToken()
+	if err != nil {
+		log.Printf("Waitlist: failed to generate claim token: %v", err)
+		return
+	}
+	expiresAt := time.Now().Add(waitlistClaimMinutes * time.Minute)
+
+	result := Models.ScopedDB(clinicGroupID).Model(&Models.WaitlistEntry{}).
+		Where("id = ? AND status = ?", entry.ID, Models.WaitlistWaiting).
+		Updates(map[string]interface{}{
+			"status":               Models.WaitlistOffered,
+			"offered_therapist_id": therapistID,
+			"offered_date_time":    dateTime,
+			"offer_token_hash":     hash,
+			"offer_expires_at":     expiresAt,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	link := fmt.Sprintf("%s/waitlist/claim?token=%s", Constants.PublicBookingURL, token)
+	if err := Whatsapp.SendMessage(entry.PhoneNumber, waitlistOfferMessage(dateTime, therapist.Name, link, waitlistClaimMinutes)); err != nil {
+		log.Printf("Waitlist: failed to send offer to entry %d: %v", entry.ID, err)
+	}
+}
+
+// ExpireWaitlistOffers moves unclaimed offers back to the end of the queue and
+// passes their slots on to the next waiting patient.
+func ExpireWaitlistOffers() error {
+	var entries []Models.WaitlistEntry
+	if err := Models.DB.Model(&Models.WaitlistEntry{}).
+		Where("status = ? AND offer_expires_at < ?", Models.WaitlistOffered, time.Now()).
+		Find(&entries).Error; err != nil {
+		return fmt.Errorf("failed to query expired waitlist offers: %w", err)
+	}
+
+	for _, entry := range entries {
+		result := Models.ScopedDB(entry.ClinicGroupID).Model(&Models.WaitlistEntry{}).
+			Where("id = ? AND status = ?", entry.ID, Models.WaitlistOffered).
+			Updates(map[string]interface{}{
+				"status":           Models.WaitlistWaiting,
+				"queued_at":        time.Now(),
+				"offer_token_hash": "",
+				"offer_expires_at": nil,
+			})
+		if result.Error != nil {
+			log.Printf("Failed to expire waitlist offer %d: %v", entry.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 1 && entry.OfferedTherapistID != nil {
+			offerFreedSlot(*entry.OfferedTherapistID, entry.OfferedDateTime, entry.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package Controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"PhysioUp/Models"
+	"PhysioUp/Utils/Token"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Longest range a single template generation request may cover
+const maxTemplateGenerationDays = 92
+
+func findOrCreateSchedule(db *gorm.DB, therapistID uint) (Models.Schedule, error) {
+	var schedule Models.Schedule
+	err := db.Where("therapist_id = ?", therapistID).First(&schedule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		schedule = Models.Schedule{TherapistID: therapistID}
+		err = db.Create(&schedule).Error
+	}
+	return schedule, err
+}
+
+func GetWorkingHoursTemplate(c *gin.Context) {
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var therapist Models.Therapist
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	schedule, err := findOrCreateSchedule(getScopedDB(c), therapist.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load schedule"})
+		return
+	}
+
+	var templates []Models.WorkingHoursTemplate
+	if err := getScopedDB(c).Where("schedule_id = ?", schedule.ID).Preload("Breaks").Order("weekday, start_time").Find(&templates).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load working hours"})
+		return
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+// SetWorkingHoursTemplate replaces the therapist's weekly template. Existing
+// time blocks, booked or not, are left untouched.
+func SetWorkingHoursTemplate(c *gin.Context) {
+	var input struct {
+		WorkingHours []Models.WorkingHoursTemplate `json:"working_hours"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	for index := range input.WorkingHours {
+		if err := input.WorkingHours[index].Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var therapist Models.Therapist
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	// Working hours have to fit inside the opening hours of the therapist's branch
+	if therapist.BranchID != nil {
+		var branch Models.Branch
+		if err := getScopedDB(c).First(&branch, *therapist.BranchID).Error; err == nil {
+			for _, template := range input.WorkingHours {
+				if !branch.CoversTemplate(template) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Working hours must be between %s and %s, when %s is open", branch.OpensAt, branch.ClosesAt, branch.Name)})
+					return
+				}
+			}
+		}
+	}
+
+	tx := getScopedDB(c).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	schedule, err := findOrCreateSchedule(tx, therapist.ID)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load schedule"})
+		return
+	}
+
+	var existingIDs []uint
+	if err := tx.Model(&Models.WorkingHoursTemplate{}).Where("schedule_id = ?", schedule.ID).Pluck("id", &existingIDs).Error; err != nil {
+		log.Println(err)
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load working hours"})
+		return
+	}
+
+	if len(existingIDs) > 0 {
+		if err := tx.Where("working_hours_template_id IN ?", existingIDs).Delete(&Models.BreakWindow{}).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear working hours"})
+			return
+		}
+		if err := tx.Delete(&Models.WorkingHoursTemplate{}, existingIDs).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear working hours"})
+			return
+		}
+	}
+
+	for index := range input.WorkingHours {
+		template := &input.WorkingHours[index]
+		template.ID = 0
+		template.ScheduleID = schedule.ID
+		for breakIndex := range template.Breaks {
+			template.Breaks[breakIndex].ID = 0
+		}
+		if err := tx.Create(template).Error; err != nil {
+			log.Println(err)
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save working hours"})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Working hours saved successfully", "working_hours": input.WorkingHours})
+}
+
+// GenerateTimeBlocksFromTemplate materialises available time blocks for every
+// day in the range, skipping slots that already have a block.
+func GenerateTimeBlocksFromTemplate(c *gin.Context) {
+	var input struct {
+		StartDate string `json:"start_date" binding:"required"`
+		EndDate   string `json:"end_date" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+		return
+	}
+
+	startDate, err := time.ParseInLocation("2006/01/02", input.StartDate, clinicLocation(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start date format. Use YYYY/MM/DD"})
+		return
+	}
+	endDate, err := time.ParseInLocation("2006/01/02", input.EndDate, clinicLocation(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end date format. Use YYYY/MM/DD"})
+		return
+	}
+	if startDate.After(endDate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start date must be before end date"})
+		return
+	}
+	if endDate.Sub(startDate) > maxTemplateGenerationDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Can't generate more than %d days at once", maxTemplateGenerationDays)})
+		return
+	}
+
+	user_id, err := Token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var therapist Models.Therapist
+	if err := getScopedDB(c).Model(&Models.Therapist{}).Where("user_id = ?", user_id).First(&therapist).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Therapist not found"})
+		return
+	}
+
+	schedule, err := findOrCreateSchedule(getScopedDB(c), therapist.ID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load schedule"})
+		return
+	}
+
+	var templates []Models.WorkingHoursTemplate
+	if err := getScopedDB(c).Where("schedule_id = ?", schedule.ID).Preload("Breaks").Find(&templates).Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load working hours"})
+		return
+	}
+	if len(templates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No working hours template configured"})
+		return
+	}
+
+	tx := getScopedDB(c).Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	created, skipped := 0, 0
+	for day := startDate; !day.After(endDate); day = day.AddDate(0, 0, 1) {
+		for index := range templates {
+			slots, err := templates[index].Slots(day)
+			if err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			for _, slot := range slots {
+				dateTime := Models.NewDateTime(slot)
+
+				// Slots already there or overlapping a booked session are left alone
+				var count int64
+				if err := tx.Model(&Models.TimeBlock{}).
+					Where("schedule_id = ? AND date_time = ?", schedule.ID, dateTime).
+					Count(&count).Error; err != nil {
+					log.Println(err)
+					tx.Rollback()
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing time blocks"})
+					return
+				}
+				booked := false
+				if count == 0 {
+					booked, err = Models.IsSlotBooked(tx, schedule.ID, Models.NewSlot(therapist, dateTime, uint(templates[index].SlotMinutes)))
+					if err != nil {
+						log.Println(err)
+						tx.Rollback()
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing time blocks"})
+						return
+					}
+				}
+				if count > 0 || booked {
+					skipped++
+					continue
+				}
+
+				// Blocks booked concurrently are skipped by the unique slot index
+				timeBlock := Models.CreateAvailableTimeBlock(schedule, dateTime)
+				timeBlock.DurationMinutes = uint(templates[index].SlotMinutes)
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&timeBlock).Error; err != nil {
+					log.Println(err)
+					tx.Rollback()
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create time block"})
+					return
+				}
+				if timeBlock.ID == 0 {
+					skipped++
+					continue
+				}
+				created++
+			}
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Time blocks generated successfully",
+		"created": created,
+		"skipped": skipped,
+	})
+}
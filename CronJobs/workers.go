@@ -1,6 +1,7 @@
 package CronJobs
 
 import (
+	"PhysioUp/Controllers"
 	"PhysioUp/Models"
 	"PhysioUp/Whatsapp"
 	"fmt"
@@ -28,6 +29,18 @@ func StartReminderCron() *gocron.Scheduler {
 		}
 	})
 
+	scheduler.Every(1).Minute().Do(func() {
+		if err := Controllers.ExpireWaitlistOffers(); err != nil {
+			log.Printf("Error expiring waitlist offers: %v", err)
+		}
+	})
+
+	scheduler.Every(1).Hour().Do(func() {
+		if err := Models.PruneStaleSessions(); err != nil {
+			log.Printf("Error pruning stale sessions: %v", err)
+		}
+	})
+
 	scheduler.StartAsync()
 	log.Println("Appointment reminder cron job started")
 
@@ -38,63 +51,19 @@ func SendAppointmentReminders() error {
 	// Current time
 	now := time.Now()
 
-	// Format dates for the query
-	todayDate := now.Format("2006/01/02")
-	// Get all of today's non-completed appointments that haven't had reminders sent yet
-	var appointments []Models.Appointment
+	// Get all non-completed appointments starting within the next 3 hours that
+	// haven't had reminders sent yet
+	var appointmentsToRemind []Models.Appointment
 	result := Models.DB.Model(&Models.Appointment{}).
-		Where("is_completed = ? AND reminder_sent = ? AND date_time::date = ?",
+		Where("is_completed = ? AND reminder_sent = ? AND date_time > ? AND date_time < ?",
 			false,
 			false,
-			todayDate).
-		Find(&appointments)
+			now,
+			now.Add(3*time.Hour)).
+		Find(&appointmentsToRemind)
 
 	if result.Error != nil {
-		return fmt.Errorf("failed to query today's appointments: %w", result.Error)
-	}
-
-	// Filter appointments that are approximately 3 hours away
-	var appointmentsToRemind []Models.Appointment
-	fmt.Println(len(appointments))
-	for _, appointment := range appointments {
-		// Parse the appointment time
-		appointmentTime, err := parseDateTime(appointment.DateTime)
-		if err != nil {
-			log.Printf("Failed to parse appointment time for ID %d: %v", appointment.ID, err)
-			continue
-		}
-
-		// Create timezone-neutral time objects
-		// This effectively strips timezone information for comparison
-		appointmentTimeNoTZ := time.Date(
-			appointmentTime.Year(),
-			appointmentTime.Month(),
-			appointmentTime.Day(),
-			appointmentTime.Hour(),
-			appointmentTime.Minute(),
-			appointmentTime.Second(),
-			appointmentTime.Nanosecond(),
-			time.UTC, // Using UTC as a neutral timezone for both times
-		)
-
-		nowNoTZ := time.Date(
-			now.Year(),
-			now.Month(),
-			now.Day(),
-			now.Hour(),
-			now.Minute(),
-			now.Second(),
-			now.Nanosecond(),
-			time.UTC, // Using UTC as a neutral timezone for both times
-		)
-
-		// Calculate time difference with timezone-neutral times
-		timeDiff := appointmentTimeNoTZ.Sub(nowNoTZ)
-
-		// Update the time comparison logic to only include upcoming appointments
-		if timeDiff > 0 && timeDiff < 3*time.Hour {
-			appointmentsToRemind = append(appointmentsToRemind, appointment)
-		}
+		return fmt.Errorf("failed to query upcoming appointments: %w", result.Error)
 	}
 
 	// Process each appointment that needs a reminder
@@ -110,14 +79,7 @@ func SendAppointmentReminders() error {
 			continue
 		}
 
-		// Parse appointment time for formatting in the message
-		appointmentTime, err := parseDateTime(appointment.DateTime)
-		if err != nil {
-			log.Printf("Failed to parse appointment time for ID %d: %v", appointment.ID, err)
-			continue
-		}
-		// In your SendAppointmentReminders function:
-		// Parse the therapist name
+		appointmentTime := appointment.DateTime
 		// Create and send reminder message
 		message := fmt.Sprintf(
 			"🔔 *APPOINTMENT REMINDER* 🔔\\n\\n"+
@@ -147,9 +109,9 @@ func SendAppointmentReminders() error {
 			continue
 		}
 
-		// Update the appointment to mark reminder as sent
-		appointment.ReminderSent = true
-		if err := Models.DB.Save(&appointment).Error; err != nil {
+		// Update the appointment to mark reminder as sent, scoped so the
+		// change is audited
+		if err := Models.ScopedDB(appointment.ClinicGroupID).Model(&appointment).Update("reminder_sent", true).Error; err != nil {
 			log.Printf("Failed to update reminder sent status for appointment ID %d: %v", appointment.ID, err)
 			// Continue anyway since the message was already sent
 		}
@@ -158,13 +120,3 @@ func SendAppointmentReminders() error {
 
 	return nil
 }
-
-func parseDateTime(dateTimeStr string) (time.Time, error) {
-	// Parse with time.UTC to ensure consistent timezone handling
-	t, err := time.Parse("2006/01/02 & 3:04 PM", dateTimeStr)
-	if err != nil {
-		return time.Time{}, err
-	}
-	// Return the time in UTC to normalize timezone
-	return t.UTC(), nil
-}
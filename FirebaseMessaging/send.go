@@ -96,6 +96,9 @@ func SendMessage(req Models.NotificationRequest) error {
 		message.Token = req.Tokens[0]
 		_, err := messagingClient.Send(ctx, message)
 		if err != nil {
+			if messaging.IsUnregistered(err) {
+				pruneTokens(req.Tokens)
+			}
 			log.Printf("Error sending message: %v", err)
 			return err
 		}
@@ -111,7 +114,7 @@ func SendMessage(req Models.NotificationRequest) error {
 			messages[i] = &messageCopy
 		}
 
-		_, err := messagingClient.SendEachForMulticast(ctx, &messaging.MulticastMessage{
+		response, err := messagingClient.SendEachForMulticast(ctx, &messaging.MulticastMessage{
 			Tokens:       req.Tokens,
 			Notification: message.Notification,
 			Data:         message.Data,
@@ -122,7 +125,27 @@ func SendMessage(req Models.NotificationRequest) error {
 			log.Printf("Error sending multicast message: %v", err)
 			return err
 		}
+		var unregistered []string
+		for i, result := range response.Responses {
+			if !result.Success && messaging.IsUnregistered(result.Error) {
+				unregistered = append(unregistered, req.Tokens[i])
+			}
+		}
+		pruneTokens(unregistered)
 	}
 	return nil
 
 }
+
+// pruneTokens removes the tokens of apps that were uninstalled or whose
+// registration expired, so they aren't sent to again.
+func pruneTokens(tokens []string) {
+	if len(tokens) == 0 {
+		return
+	}
+	if err := Models.PruneDeviceTokens(tokens); err != nil {
+		log.Printf("Error pruning unregistered device tokens: %v", err)
+		return
+	}
+	log.Printf("Pruned %d unregistered device tokens", len(tokens))
+}
@@ -1,16 +1,50 @@
 package Middleware
 
 import (
-	"fmt"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"net/http"
+	"os"
 
 	"PhysioUp/Models"
 	"PhysioUp/Utils/Token"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
+// RequestID tags every request with an ID, stored as "requestID" and sent
+// back in the X-Request-ID header, so audit log entries can be traced to it.
+// A well formed ID sent by the client, like one from a proxy, is kept.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if !validRequestID(requestID) {
+			buffer := make([]byte, 16)
+			if _, err := rand.Read(buffer); err == nil {
+				requestID = hex.EncodeToString(buffer)
+			} else {
+				requestID = ""
+			}
+		}
+		c.Set("requestID", requestID)
+		c.Header("X-Request-ID", requestID)
+		c.Next()
+	}
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 64 {
+		return false
+	}
+	for _, char := range requestID {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-' || char == '_' || char == '.') {
+			return false
+		}
+	}
+	return true
+}
+
 func JwtAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		err := Token.TokenValid(c)
@@ -19,6 +53,50 @@ func JwtAuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		// Logging out or freezing the user revokes the session right away
+		userID, userErr := Token.ExtractTokenID(c)
+		sessionID, sessionErr := Token.ExtractSessionID(c)
+		if userErr != nil || sessionErr != nil || !Models.SessionActive(sessionID, userID) {
+			c.String(http.StatusUnauthorized, "Unauthorized Session Revoked")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ResolveClinicSlug resolves the clinic group of the public /c/:slug routes
+// and stores its ID as "clinicGroupID".
+func ResolveClinicSlug() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		group, err := Models.ClinicGroupBySlug(c.Param("slug"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Clinic not found"})
+			c.Abort()
+			return
+		}
+		c.Set("clinicGroupID", group.ID)
+		c.Next()
+	}
+}
+
+// PatientAuthMiddleware admits requests carrying a patient portal token and
+// stores the patient's ID as "patientID".
+func PatientAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		patientID, err := Token.ExtractPatientID(c)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+		var count int64
+		if err := Models.DB.Model(&Models.Patient{}).Where("id = ?", patientID).Count(&count).Error; err != nil || count == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Patient not found"})
+			c.Abort()
+			return
+		}
+		c.Set("patientID", patientID)
 		c.Next()
 	}
 }
@@ -41,48 +119,53 @@ func SetClinicGroup() gin.HandlerFunc {
 			return
 		}
 
-		fmt.Println("User's ClinicGroupID:", user.ClinicGroupID)
-
-		// Store both the original DB and the clinic group ID
+		// Handlers query through a session scoped to this clinic group
 		c.Set("clinicGroupID", user.ClinicGroupID)
-
-		// Create a custom wrapper function to apply filtering
-		dbWrapper := func(tableName string) *gorm.DB {
-			fmt.Println("TableName: " + tableName)
-			if tableName == "" {
-				return Models.DB.Where("clinic_group_id = ?", user.ClinicGroupID)
-			}
-			return Models.DB.Where(fmt.Sprintf("%s.clinic_group_id = ?", tableName), user.ClinicGroupID)
-		}
-
-		c.Set("db", dbWrapper)
+		c.Set("role", user.Role)
+		c.Set("userID", user.ID)
+		c.Set("mustChangePassword", user.MustChangePassword)
 		c.Next()
 	}
 }
 
-func PermissionCheckAdmin() gin.HandlerFunc {
+// RequirePasswordChanged turns away users still on a temporary password set
+// by their owner until they change it. It runs after SetClinicGroup.
+func RequirePasswordChanged() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id, err := Token.ExtractTokenID(c)
-
-		if err != nil {
-			c.String(http.StatusBadRequest, "Unauthorized Token Extraction")
+		if c.GetBool("mustChangePassword") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Change your temporary password first", "must_change_password": true})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		user, err := Models.GetUserByID(user_id)
-		if err != nil {
-			c.String(http.StatusBadRequest, "Unauthorized User Extraction")
+// RequireAdminKey guards platform level routes with the ADMIN_KEY set on the
+// server, sent in the X-Admin-Key header. They are disabled when none is set.
+func RequireAdminKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminKey := os.Getenv("ADMIN_KEY")
+		if adminKey == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Admin-Key")), []byte(adminKey)) != 1 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		if user.Permission >= 2 {
-			c.Next()
-		} else {
-			c.String(http.StatusBadRequest, "Unauthorized Not Enough Permission")
+// RequirePermission lets the request through only when the role set by
+// SetClinicGroup grants the permission.
+func RequirePermission(permission Models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("role")
+		if userRole, ok := role.(Models.Role); !ok || !userRole.Can(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Your role doesn't allow this action"})
 			c.Abort()
+			return
 		}
+		c.Next()
 	}
 }
 
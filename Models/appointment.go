@@ -1,14 +1,17 @@
 package Models
 
 import (
-	"math/rand"
+	"errors"
 
 	"gorm.io/gorm"
 )
 
+// DateTimeLayout is the format used for appointment and time block date times.
+const DateTimeLayout = "2006/01/02 & 3:04 PM"
+
 type Appointment struct {
 	gorm.Model
-	DateTime        string `json:"date_time"`
+	DateTime        DateTime `json:"date_time"`
 	TimeBlockID     uint
 	TherapistID     uint    `json:"therapist_id"`
 	TherapistName   string  `json:"therapist_name"`
@@ -22,27 +25,45 @@ type Appointment struct {
 	TreatmentPlanID *uint   `json:"treatment_plan_id" gorm:"default:null"`
 	ReminderSent    bool    `json:"reminder_sent"`
 	ClinicGroupID   uint    `json:"clinic_group_id"`
+	BranchID        *uint   `json:"branch_id" gorm:"index;default:null"` // the therapist's branch when booked
 }
 
 type AppointmentRequest struct {
 	gorm.Model
-	DateTime                      string `json:"date_time"`
-	TherapistID                   uint   `json:"therapist_id"`
-	TherapistName                 string `json:"therapist_name"`
-	PatientName                   string `json:"patient_name"`
-	PatientID                     uint   `json:"patient_id"`
-	PhoneNumber                   string `json:"phone_number"`
-	SuperTreatmentPlanDescription string `json:"super_treatment_plan_description"`
-	IsExisting                    bool   `json:"is_existing" gorm:"-"`
-	ClinicGroupID                 uint   `json:"clinic_group_id"`
+	DateTime                      DateTime `json:"date_time"`
+	TherapistID                   uint     `json:"therapist_id"`
+	TherapistName                 string   `json:"therapist_name"`
+	PatientName                   string   `json:"patient_name"`
+	PatientID                     uint     `json:"patient_id"`
+	PhoneNumber                   string   `json:"phone_number"`
+	SuperTreatmentPlanDescription string   `json:"super_treatment_plan_description"`
+	IsExisting                    bool     `json:"is_existing" gorm:"-"`
+	TimeBlockID                   *uint    `json:"time_block_id" gorm:"default:null"` // group session to join
+	ClinicGroupID                 uint     `json:"clinic_group_id"`
 }
 
-func (patient *Patient) GenerateOTPToken(count int) {
-	var possibleCharacters = []rune("1234567890")
-
-	token := make([]rune, count)
-	for index := range token {
-		token[index] = possibleCharacters[rand.Intn(len(possibleCharacters))]
+// Appointments are kept in the branch of their therapist, so reports of a
+// branch still count them after the therapist moves
+func (appointment *Appointment) BeforeCreate(tx *gorm.DB) error {
+	if appointment.TherapistID == 0 {
+		return nil
 	}
-	patient.OTP = string(token)
+	var therapist Therapist
+	err := tx.Session(&gorm.Session{NewDB: true}).Select("id", "branch_id").First(&therapist, appointment.TherapistID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	appointment.BranchID = therapist.BranchID
+	return err
+}
+
+// Date times are rendered in the clinic group's time zone
+func (appointment *Appointment) AfterFind(tx *gorm.DB) error {
+	appointment.DateTime = appointment.DateTime.InLocation(ClinicGroupLocation(appointment.ClinicGroupID))
+	return nil
+}
+
+func (request *AppointmentRequest) AfterFind(tx *gorm.DB) error {
+	request.DateTime = request.DateTime.InLocation(ClinicGroupLocation(request.ClinicGroupID))
+	return nil
 }
@@ -0,0 +1,291 @@
+package Models
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+type auditActorKey struct{}
+type auditRequestKey struct{}
+
+// AuditLog records one change to a tenant owned row, written in the same
+// transaction as the change. Updates keep only the columns that changed.
+type AuditLog struct {
+	gorm.Model
+	ClinicGroupID uint            `json:"clinic_group_id" gorm:"index"`
+	ActorID       *uint           `json:"actor_id" gorm:"index"` // nil for changes made by patients or through public links
+	RequestID     string          `json:"request_id" gorm:"index"`
+	Action        string          `json:"action" gorm:"size:16"`
+	Entity        string          `json:"entity" gorm:"index"` // table name
+	RecordID      uint            `json:"record_id" gorm:"index"`
+	Before        json.RawMessage `json:"before" gorm:"type:jsonb"`
+	After         json.RawMessage `json:"after" gorm:"type:jsonb"`
+}
+
+// WithAuditActor returns a session whose changes are attributed to the staff
+// user and request in the audit log. Either can be zero.
+func WithAuditActor(db *gorm.DB, actorID uint, requestID string) *gorm.DB {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if actorID != 0 {
+		ctx = context.WithValue(ctx, auditActorKey{}, actorID)
+	}
+	if requestID != "" {
+		ctx = context.WithValue(ctx, auditRequestKey{}, requestID)
+	}
+	return db.WithContext(ctx)
+}
+
+// Columns that never appear in the audit log, like password hashes and
+// secrets. Fields hidden from JSON are left out too.
+var auditRedactedColumns = map[string]bool{"password": true}
+
+const auditRedacted = "[redacted]"
+
+// auditable reports whether changes made through the statement are audited:
+// it has to be scoped to a clinic group and its model tenant owned.
+func auditable(db *gorm.DB) bool {
+	if _, ok := ScopedClinicGroupID(db); !ok || db.Error != nil {
+		return false
+	}
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil || stmt.Schema.Table == "audit_logs" {
+		return false
+	}
+	if stmt.Schema.LookUpField("ClinicGroupID") != nil {
+		return true
+	}
+	for _, owner := range tenantOwners {
+		if stmt.Schema.LookUpField(owner.Field) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// auditPrimaryKeys returns the primary keys held by the statement's value.
+func auditPrimaryKeys(stmt *gorm.Statement) []interface{} {
+	if !stmt.ReflectValue.IsValid() {
+		return nil
+	}
+	_, values := schema.GetIdentityFieldValuesMap(stmt.Context, stmt.ReflectValue, []*schema.Field{stmt.Schema.PrioritizedPrimaryField})
+	keys := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		keys = append(keys, value[0])
+	}
+	return keys
+}
+
+// auditRows loads the rows a statement affects as column maps. withWhere
+// copies the statement's conditions, otherwise only keys are matched.
+func auditRows(db *gorm.DB, keys []interface{}, withWhere bool) ([]map[string]interface{}, error) {
+	stmt := db.Statement
+	query := db.Session(&gorm.Session{NewDB: true}).Model(reflect.New(stmt.Schema.ModelType).Interface())
+	if stmt.Unscoped || !withWhere {
+		query = query.Unscoped()
+	}
+	if withWhere {
+		c, ok := stmt.Clauses["WHERE"]
+		where, isWhere := c.Expression.(clause.Where)
+		if ok && isWhere && len(where.Exprs) > 0 {
+			query.Statement.AddClause(where)
+		} else if len(keys) == 0 {
+			// Nothing narrows the statement down, gorm refuses to run it
+			return nil, nil
+		}
+	}
+	if len(keys) > 0 {
+		query = query.Where(clause.IN{
+			Column: clause.Column{Table: clause.CurrentTable, Name: stmt.Schema.PrioritizedPrimaryField.DBName},
+			Values: keys,
+		})
+	} else if !withWhere {
+		return nil, nil
+	}
+
+	var rows []map[string]interface{}
+	err := query.Find(&rows).Error
+	return rows, err
+}
+
+func auditHidden(stmt *gorm.Statement, column string) bool {
+	if auditRedactedColumns[column] {
+		return true
+	}
+	field := stmt.Schema.LookUpField(column)
+	return field != nil && field.Tag.Get("json") == "-"
+}
+
+func auditSnapshot(stmt *gorm.Statement, row map[string]interface{}) json.RawMessage {
+	snapshot := map[string]interface{}{}
+	for column, value := range row {
+		if !auditHidden(stmt, column) {
+			snapshot[column] = value
+		}
+	}
+	data, _ := json.Marshal(snapshot)
+	return data
+}
+
+// auditDiff returns the columns that changed between two versions of a row.
+// Hidden columns only show that they changed.
+func auditDiff(stmt *gorm.Statement, before map[string]interface{}, after map[string]interface{}) (json.RawMessage, json.RawMessage, bool) {
+	from := map[string]interface{}{}
+	to := map[string]interface{}{}
+	for column, value := range after {
+		if column == "updated_at" || reflect.DeepEqual(before[column], value) {
+			continue
+		}
+		if auditHidden(stmt, column) {
+			from[column], to[column] = auditRedacted, auditRedacted
+			continue
+		}
+		from[column], to[column] = before[column], value
+	}
+	if len(to) == 0 {
+		return nil, nil, false
+	}
+	fromData, _ := json.Marshal(from)
+	toData, _ := json.Marshal(to)
+	return fromData, toData, true
+}
+
+func auditRowID(stmt *gorm.Statement, row map[string]interface{}) uint {
+	switch id := row[stmt.Schema.PrioritizedPrimaryField.DBName].(type) {
+	case int64:
+		return uint(id)
+	case int32:
+		return uint(id)
+	case uint:
+		return id
+	}
+	return 0
+}
+
+func writeAuditLogs(db *gorm.DB, logs []AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	clinicGroupID, _ := ScopedClinicGroupID(db)
+	var actorID *uint
+	if id, ok := db.Statement.Context.Value(auditActorKey{}).(uint); ok {
+		actorID = &id
+	}
+	requestID, _ := db.Statement.Context.Value(auditRequestKey{}).(string)
+	for index := range logs {
+		logs[index].ClinicGroupID = clinicGroupID
+		logs[index].ActorID = actorID
+		logs[index].RequestID = requestID
+		logs[index].Entity = db.Statement.Schema.Table
+	}
+	// A change that can't be audited is rolled back with its transaction
+	if err := db.Session(&gorm.Session{NewDB: true}).Create(&logs).Error; err != nil {
+		db.AddError(err)
+	}
+}
+
+func auditCreate(db *gorm.DB) {
+	if !auditable(db) {
+		return
+	}
+	rows, err := auditRows(db, auditPrimaryKeys(db.Statement), false)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	logs := make([]AuditLog, 0, len(rows))
+	for _, row := range rows {
+		logs = append(logs, AuditLog{Action: AuditCreate, RecordID: auditRowID(db.Statement, row), After: auditSnapshot(db.Statement, row)})
+	}
+	writeAuditLogs(db, logs)
+}
+
+// auditBefore stashes the rows an update or delete is about to change.
+func auditBefore(db *gorm.DB) {
+	if !auditable(db) {
+		return
+	}
+	rows, err := auditRows(db, auditPrimaryKeys(db.Statement), true)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	db.InstanceSet("audit:before", rows)
+}
+
+func auditUpdate(db *gorm.DB) {
+	value, ok := db.InstanceGet("audit:before")
+	if !ok || db.Error != nil {
+		return
+	}
+	before, _ := value.([]map[string]interface{})
+	if len(before) == 0 {
+		return
+	}
+	keys := make([]interface{}, len(before))
+	beforeByID := make(map[uint]map[string]interface{}, len(before))
+	for index, row := range before {
+		keys[index] = row[db.Statement.Schema.PrioritizedPrimaryField.DBName]
+		beforeByID[auditRowID(db.Statement, row)] = row
+	}
+	after, err := auditRows(db, keys, false)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+
+	logs := make([]AuditLog, 0, len(after))
+	for _, row := range after {
+		id := auditRowID(db.Statement, row)
+		if from, to, changed := auditDiff(db.Statement, beforeByID[id], row); changed {
+			logs = append(logs, AuditLog{Action: AuditUpdate, RecordID: id, Before: from, After: to})
+		}
+	}
+	writeAuditLogs(db, logs)
+}
+
+func auditDelete(db *gorm.DB) {
+	value, ok := db.InstanceGet("audit:before")
+	if !ok || db.Error != nil || db.RowsAffected == 0 {
+		return
+	}
+	before, _ := value.([]map[string]interface{})
+	logs := make([]AuditLog, 0, len(before))
+	for _, row := range before {
+		logs = append(logs, AuditLog{Action: AuditDelete, RecordID: auditRowID(db.Statement, row), Before: auditSnapshot(db.Statement, row)})
+	}
+	writeAuditLogs(db, logs)
+}
+
+// registerAuditCallbacks writes an AuditLog for every create, update and
+// delete of a tenant model made through a ScopedDB session. It runs after
+// the tenancy callbacks so the rows it reads are the ones being changed.
+func registerAuditCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().After("gorm:create").Register("audit:create", auditCreate); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("tenancy:update").Before("gorm:update").Register("audit:before_update", auditBefore); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").Register("audit:update", auditUpdate); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("tenancy:delete").Before("gorm:delete").Register("audit:before_delete", auditBefore); err != nil {
+		return err
+	}
+	return callbacks.Delete().After("gorm:delete").Register("audit:delete", auditDelete)
+}
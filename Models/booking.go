@@ -0,0 +1,117 @@
+package Models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTimeBlockTaken = errors.New("time block already booked")
+
+// DefaultSessionMinutes is used when neither the package nor the therapist
+// sets a session length.
+const DefaultSessionMinutes = 30
+
+// Slot is the interval a booking occupies on a therapist's schedule.
+type Slot struct {
+	DateTime        DateTime
+	DurationMinutes uint
+	BufferMinutes   uint
+	RoomID          *uint
+	EquipmentID     *uint
+}
+
+// NewSlot builds the slot for a session with the therapist at dateTime. A zero
+// durationMinutes falls back to the therapist's session length.
+func NewSlot(therapist Therapist, dateTime DateTime, durationMinutes uint) Slot {
+	if durationMinutes == 0 {
+		durationMinutes = therapist.SessionMinutes
+	}
+	if durationMinutes == 0 {
+		durationMinutes = DefaultSessionMinutes
+	}
+	return Slot{DateTime: dateTime, DurationMinutes: durationMinutes, BufferMinutes: therapist.BufferMinutes}
+}
+
+func (slot Slot) BusyUntil() time.Time {
+	return slot.DateTime.Add(time.Duration(slot.DurationMinutes+slot.BufferMinutes) * time.Minute)
+}
+
+// overlappingTimeBlocks selects the booked blocks on the schedule whose
+// session or buffer intersects the slot.
+func overlappingTimeBlocks(db *gorm.DB, scheduleID uint, slot Slot) *gorm.DB {
+	return db.Model(&TimeBlock{}).
+		Where("schedule_id = ? AND is_available = ?", scheduleID, false).
+		Where("date_time < ? AND date_time + (duration_minutes + buffer_minutes) * INTERVAL '1 minute' > ?", slot.BusyUntil(), slot.DateTime)
+}
+
+// BookTimeBlock reserves the slot on the schedule inside tx. An available
+// block generated from a working hours template is claimed in place,
+// otherwise a new block is inserted. The schedule row is locked for the rest
+// of tx so that only one of several concurrent bookings of overlapping slots
+// succeeds; the others get ErrTimeBlockTaken.
+func BookTimeBlock(tx *gorm.DB, schedule Schedule, slot Slot) (TimeBlock, error) {
+	var timeBlock TimeBlock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&Schedule{}, schedule.ID).Error; err != nil {
+		return timeBlock, err
+	}
+
+	booked, err := IsSlotBooked(tx, schedule.ID, slot)
+	if err != nil {
+		return timeBlock, err
+	}
+	if booked {
+		return timeBlock, ErrTimeBlockTaken
+	}
+
+	err = tx.Where("schedule_id = ? AND date_time = ?", schedule.ID, slot.DateTime).First(&timeBlock).Error
+	if err == nil {
+		result := tx.Model(&TimeBlock{}).
+			Where("id = ? AND is_available = ?", timeBlock.ID, true).
+			Updates(map[string]interface{}{
+				"is_available":     false,
+				"duration_minutes": slot.DurationMinutes,
+				"buffer_minutes":   slot.BufferMinutes,
+				"room_id":          slot.RoomID,
+				"equipment_id":     slot.EquipmentID,
+			})
+		if result.Error != nil {
+			return timeBlock, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return timeBlock, ErrTimeBlockTaken
+		}
+		timeBlock.IsAvailable = false
+		timeBlock.DurationMinutes = slot.DurationMinutes
+		timeBlock.BufferMinutes = slot.BufferMinutes
+		timeBlock.RoomID = slot.RoomID
+		timeBlock.EquipmentID = slot.EquipmentID
+		return timeBlock, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return timeBlock, err
+	}
+
+	timeBlock = CreateEmptyTimeBlock(schedule, slot.DateTime)
+	timeBlock.DurationMinutes = slot.DurationMinutes
+	timeBlock.BufferMinutes = slot.BufferMinutes
+	timeBlock.RoomID = slot.RoomID
+	timeBlock.EquipmentID = slot.EquipmentID
+	if err := tx.Create(&timeBlock).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return timeBlock, ErrTimeBlockTaken
+		}
+		return timeBlock, err
+	}
+	return timeBlock, nil
+}
+
+// IsSlotBooked reports whether a booked block on the schedule overlaps the
+// slot, buffers included.
+func IsSlotBooked(db *gorm.DB, scheduleID uint, slot Slot) (bool, error) {
+	var count int64
+	err := overlappingTimeBlocks(db, scheduleID, slot).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,115 @@
+package Models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrBranchClosed = errors.New("branch is closed at this time")
+
+// Branch is one location of a clinic group. Therapists, rooms and equipment
+// can be assigned to a branch, while patients and packages stay shared by
+// the whole group.
+type Branch struct {
+	gorm.Model
+	Name          string `json:"name"`
+	Address       string `json:"address"`
+	Phone         string `json:"phone"`
+	OpensAt       string `json:"opens_at"`  // "15:04", empty when the branch has no opening hours
+	ClosesAt      string `json:"closes_at"` // "15:04"
+	ClinicGroupID uint   `json:"clinic_group_id" gorm:"index"`
+}
+
+func (branch *Branch) Validate() error {
+	if branch.OpensAt == "" && branch.ClosesAt == "" {
+		return nil
+	}
+	opens, err := parseClock(branch.OpensAt)
+	if err != nil {
+		return err
+	}
+	closes, err := parseClock(branch.ClosesAt)
+	if err != nil {
+		return err
+	}
+	if opens >= closes {
+		return errors.New("opening time must be before closing time")
+	}
+	return nil
+}
+
+// openingHours returns the opening and closing time as offsets from midnight,
+// with ok false when the branch is open around the clock.
+func (branch *Branch) openingHours() (opens time.Duration, closes time.Duration, ok bool) {
+	if branch.OpensAt == "" || branch.ClosesAt == "" {
+		return 0, 0, false
+	}
+	opens, err := parseClock(branch.OpensAt)
+	if err != nil {
+		return 0, 0, false
+	}
+	closes, err = parseClock(branch.ClosesAt)
+	if err != nil {
+		return 0, 0, false
+	}
+	return opens, closes, true
+}
+
+// CoversTemplate reports whether the working hours fall inside the branch's
+// opening hours.
+func (branch *Branch) CoversTemplate(template WorkingHoursTemplate) bool {
+	opens, closes, ok := branch.openingHours()
+	if !ok {
+		return true
+	}
+	start, err := parseClock(template.StartTime)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(template.EndTime)
+	if err != nil {
+		return false
+	}
+	return start >= opens && end <= closes
+}
+
+// CheckBranchHours returns ErrBranchClosed when the session in the slot
+// doesn't fit inside the opening hours of the branch. Therapists without a
+// branch can be booked at any time.
+func CheckBranchHours(db *gorm.DB, branchID *uint, slot Slot) error {
+	if branchID == nil {
+		return nil
+	}
+	var branch Branch
+	if err := db.Select("id", "opens_at", "closes_at", "clinic_group_id").First(&branch, *branchID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	opens, closes, ok := branch.openingHours()
+	if !ok {
+		return nil
+	}
+
+	start := slot.DateTime.In(ClinicGroupLocation(branch.ClinicGroupID))
+	midnight := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	end := start.Add(time.Duration(slot.DurationMinutes) * time.Minute)
+	if start.Sub(midnight) < opens || end.Sub(midnight) > closes {
+		return ErrBranchClosed
+	}
+	return nil
+}
+
+// InBranch narrows a query on a model with a nullable branch_id to rows of
+// the branch or shared by every branch. A nil branch matches every row.
+func InBranch(branchID *uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if branchID == nil {
+			return db
+		}
+		return db.Where("branch_id IS NULL OR branch_id = ?", *branchID)
+	}
+}
@@ -0,0 +1,125 @@
+package Models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultLocation is the time zone legacy date time strings are interpreted
+// in. It is loaded from TIME_ZONE when connecting to the database.
+var DefaultLocation = time.Local
+
+// DateTime is stored as a timestamptz column but keeps the legacy
+// "2006/01/02 & 3:04 PM" representation in JSON so existing frontends keep
+// working.
+type DateTime struct {
+	time.Time
+}
+
+var legacyDateTimeLayouts = []string{DateTimeLayout, "2006/01/02 & 03:04 PM"}
+
+func NewDateTime(t time.Time) DateTime {
+	return DateTime{Time: t.In(DefaultLocation)}
+}
+
+// ParseDateTime accepts both legacy layouts. Values carry no zone and are read
+// as wall clock time in loc.
+func ParseDateTime(value string, loc *time.Location) (DateTime, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range legacyDateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return DateTime{Time: t}, nil
+		}
+	}
+	return DateTime{}, fmt.Errorf("invalid date time %q, use YYYY/MM/DD & H:MM AM", value)
+}
+
+func (dt DateTime) String() string {
+	if dt.IsZero() {
+		return ""
+	}
+	return dt.Format(DateTimeLayout)
+}
+
+func (dt DateTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dt.String())
+}
+
+func (dt *DateTime) UnmarshalJSON(data []byte) error {
+	var value string
+	if string(data) == "null" {
+		*dt = DateTime{}
+		return nil
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value == "" {
+		*dt = DateTime{}
+		return nil
+	}
+	parsed, err := ParseDateTime(value, DefaultLocation)
+	if err != nil {
+		return err
+	}
+	*dt = parsed
+	return nil
+}
+
+func (dt *DateTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*dt = DateTime{}
+	case time.Time:
+		*dt = NewDateTime(v)
+	case string:
+		parsed, err := ParseDateTime(v, DefaultLocation)
+		if err != nil {
+			return err
+		}
+		*dt = parsed
+	case []byte:
+		return dt.Scan(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into DateTime", value)
+	}
+	return nil
+}
+
+func (dt DateTime) Value() (driver.Value, error) {
+	if dt.IsZero() {
+		return nil, nil
+	}
+	return dt.Time, nil
+}
+
+func (DateTime) GormDataType() string {
+	return "timestamptz"
+}
+
+// InLocation converts dt to loc without changing the instant it represents.
+func (dt DateTime) InLocation(loc *time.Location) DateTime {
+	if dt.IsZero() {
+		return dt
+	}
+	return DateTime{Time: dt.Time.In(loc)}
+}
+
+// WallClockIn keeps the wall clock reading of dt but moves it to loc. JSON
+// input carries no zone, so handlers use it once the clinic group is known.
+func (dt DateTime) WallClockIn(loc *time.Location) DateTime {
+	if dt.IsZero() {
+		return dt
+	}
+	return DateTime{Time: time.Date(dt.Year(), dt.Month(), dt.Day(), dt.Hour(), dt.Minute(), dt.Second(), dt.Nanosecond(), loc)}
+}
+
+// DayBounds returns the start of the day dt falls on and the start of the
+// following day, in dt's location.
+func (dt DateTime) DayBounds() (time.Time, time.Time) {
+	start := time.Date(dt.Year(), dt.Month(), dt.Day(), 0, 0, 0, 0, dt.Location())
+	return start, start.AddDate(0, 0, 1)
+}
@@ -1,8 +1,130 @@
 package Models
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type ClinicGroup struct {
 	gorm.Model
-	Name string `json:"name" gorm:"unique"`
+	Name     string `json:"name" gorm:"unique"`
+	TimeZone string `json:"time_zone"` // IANA name, e.g. "Africa/Cairo"; empty uses DefaultLocation
+	// Public booking routes are served under /api/c/<slug>
+	Slug string `json:"slug" gorm:"uniqueIndex:idx_clinic_groups_slug,where:slug <> ''"`
+	// Patients can't cancel or reschedule through their link within this many hours of the appointment
+	CancellationCutoffHours uint `json:"cancellation_cutoff_hours" gorm:"default:24"`
+	// Staff have to set up two-factor authentication before they can log in
+	RequireTOTP bool `json:"require_totp"`
+}
+
+var (
+	clinicGroupLocations sync.Map // clinic group ID -> *time.Location
+	scheduleClinicGroups sync.Map // schedule ID -> clinic group ID
+)
+
+func (group *ClinicGroup) Location() *time.Location {
+	if group.TimeZone == "" {
+		return DefaultLocation
+	}
+	location, err := time.LoadLocation(group.TimeZone)
+	if err != nil {
+		log.Printf("Invalid time zone %q for clinic group %d: %v", group.TimeZone, group.ID, err)
+		return DefaultLocation
+	}
+	return location
+}
+
+// ClinicGroupLocation returns the time zone of a clinic group, falling back to
+// DefaultLocation when the group is unknown.
+func ClinicGroupLocation(id uint) *time.Location {
+	if id == 0 {
+		return DefaultLocation
+	}
+	if location, ok := clinicGroupLocations.Load(id); ok {
+		return location.(*time.Location)
+	}
+	var group ClinicGroup
+	if err := DB.Select("id", "time_zone").First(&group, id).Error; err != nil {
+		return DefaultLocation
+	}
+	location := group.Location()
+	clinicGroupLocations.Store(id, location)
+	return location
+}
+
+var (
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+func ValidSlug(slug string) bool {
+	return len(slug) >= 3 && len(slug) <= 63 && slugPattern.MatchString(slug)
+}
+
+// UniqueClinicGroupSlug derives a free slug from name, adding a number when
+// it is already taken.
+func UniqueClinicGroupSlug(db *gorm.DB, name string) (string, error) {
+	base := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(base) > 56 {
+		base = strings.Trim(base[:56], "-")
+	}
+	if len(base) < 3 {
+		base = strings.Trim(base+"-clinic", "-")
+	}
+
+	slug := base
+	for suffix := 2; ; suffix++ {
+		var count int64
+		if err := db.Unscoped().Model(&ClinicGroup{}).Where("slug = ?", slug).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, suffix)
+	}
+}
+
+func ClinicGroupBySlug(slug string) (ClinicGroup, error) {
+	var group ClinicGroup
+	if slug == "" {
+		return group, gorm.ErrRecordNotFound
+	}
+	err := DB.Where("slug = ?", slug).First(&group).Error
+	return group, err
+}
+
+func InvalidateClinicGroupLocation(id uint) {
+	clinicGroupLocations.Delete(id)
+}
+
+// ScheduleClinicGroupID resolves the clinic group a schedule belongs to
+// through its therapist's user.
+func ScheduleClinicGroupID(scheduleID uint) uint {
+	if id, ok := scheduleClinicGroups.Load(scheduleID); ok {
+		return id.(uint)
+	}
+	var clinicGroupID uint
+	if err := DB.Table("schedules").
+		Select("users.clinic_group_id").
+		Joins("JOIN therapists ON therapists.id = schedules.therapist_id").
+		Joins("JOIN users ON users.id = therapists.user_id").
+		Where("schedules.id = ?", scheduleID).
+		Scan(&clinicGroupID).Error; err != nil || clinicGroupID == 0 {
+		return 0
+	}
+	scheduleClinicGroups.Store(scheduleID, clinicGroupID)
+	return clinicGroupID
+}
+
+// Today returns midnight of the current day in loc.
+func Today(loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
 }
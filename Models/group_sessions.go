@@ -0,0 +1,51 @@
+package Models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotGroupSession   = errors.New("time block is not a group session")
+	ErrGroupSessionFull  = errors.New("group session is full")
+	ErrGroupSessionEnded = errors.New("group session has been cancelled")
+)
+
+// SeatsLeft returns how many more patients can join the block. It expects
+// Appointments to be preloaded.
+func (timeBlock TimeBlock) SeatsLeft() uint {
+	if !timeBlock.IsGroup || uint(len(timeBlock.Appointments)) >= timeBlock.Capacity {
+		return 0
+	}
+	return timeBlock.Capacity - uint(len(timeBlock.Appointments))
+}
+
+// JoinGroupSession reserves a seat in a group session of the therapist inside
+// tx. The block row is locked for the rest of tx so concurrent joins can't
+// exceed its capacity.
+func JoinGroupSession(tx *gorm.DB, therapist Therapist, timeBlockID uint) (TimeBlock, error) {
+	var timeBlock TimeBlock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&timeBlock, timeBlockID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return timeBlock, ErrGroupSessionEnded
+		}
+		return timeBlock, err
+	}
+	if !timeBlock.IsGroup {
+		return timeBlock, ErrNotGroupSession
+	}
+	if err := CheckBookable(tx, therapist.ID, therapist.ClinicGroupID, timeBlock.Slot()); err != nil {
+		return timeBlock, err
+	}
+
+	var attendees int64
+	if err := tx.Model(&Appointment{}).Where("time_block_id = ?", timeBlock.ID).Count(&attendees).Error; err != nil {
+		return timeBlock, err
+	}
+	if uint(attendees) >= timeBlock.Capacity {
+		return timeBlock, ErrGroupSessionFull
+	}
+	return timeBlock, nil
+}
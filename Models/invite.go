@@ -0,0 +1,104 @@
+package Models
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultInviteTTL = 7 * 24 * time.Hour
+	MaxInviteTTL     = 30 * 24 * time.Hour
+)
+
+var ErrInviteInvalid = errors.New("invite is invalid, used or expired")
+
+// Invite lets one person sign up as a user of a clinic group with a fixed
+// role. Only the hash of the token is stored.
+type Invite struct {
+	gorm.Model
+	TokenHash     string     `json:"-" gorm:"uniqueIndex"`
+	Role          Role       `json:"role" gorm:"size:32"`
+	ClinicGroupID uint       `json:"clinic_group_id" gorm:"index"`
+	CreatedByID   uint       `json:"created_by_id"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at"`
+	UsedByID      *uint      `json:"used_by_id"`
+}
+
+// CreateInvite stores a new invite and returns it with the token to hand out.
+func CreateInvite(db *gorm.DB, clinicGroupID uint, role Role, createdByID uint, ttl time.Duration) (Invite, string, error) {
+	token, hash, err := GenerateSecureToken()
+	if err != nil {
+		return Invite{}, "", err
+	}
+	invite := Invite{
+		TokenHash:     hash,
+		Role:          role,
+		ClinicGroupID: clinicGroupID,
+		CreatedByID:   createdByID,
+		ExpiresAt:     time.Now().Add(ttl),
+	}
+	err = db.Create(&invite).Error
+	return invite, token, err
+}
+
+// RedeemInvite creates the user the invite was made for and marks it used,
+// in one transaction so a token can only be redeemed once. Therapists also
+// get their therapist profile. It fails with ErrInviteInvalid for unknown,
+// used and expired tokens and gorm.ErrDuplicatedKey for a taken username.
+func RedeemInvite(token string, username string, password string, phone string) (User, error) {
+	var user User
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var invite Invite
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", HashToken(token)).First(&invite).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInviteInvalid
+			}
+			return err
+		}
+		if invite.UsedAt != nil || time.Now().After(invite.ExpiresAt) || !invite.Role.Valid() {
+			return ErrInviteInvalid
+		}
+
+		user = User{
+			Username:      username,
+			Password:      password,
+			Phone:         phone,
+			Role:          invite.Role,
+			Permission:    invite.Role.LegacyPermission(),
+			ClinicGroupID: invite.ClinicGroupID,
+		}
+		if err := user.BeforeSave(); err != nil {
+			return err
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+
+		if invite.Role == RoleTherapist {
+			therapist := Therapist{
+				Name:          "Dr. " + html.EscapeString(strings.TrimSpace(username)),
+				UserID:        user.ID,
+				ClinicGroupID: invite.ClinicGroupID,
+			}
+			if err := tx.Create(&therapist).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&Schedule{TherapistID: therapist.ID}).Error; err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		return tx.Model(&Invite{}).Where("id = ?", invite.ID).
+			Updates(map[string]interface{}{"used_at": now, "used_by_id": user.ID}).Error
+	})
+	user.PrepareGive()
+	return user, err
+}
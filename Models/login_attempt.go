@@ -0,0 +1,118 @@
+package Models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Failed logins are counted over this window, per username and per IP
+	LoginAttemptWindow     = 15 * time.Minute
+	MaxFailedLoginsPerUser = 5
+	MaxFailedLoginsPerIP   = 20
+	// How long logins stay blocked after the last failure that hit a limit
+	LoginLockout = 15 * time.Minute
+)
+
+// Reasons a login attempt failed
+const (
+	LoginFailedPassword = "password"
+	LoginFailedTOTP     = "totp"
+	LoginFailedFrozen   = "frozen"
+	LoginFailedLocked   = "locked"
+)
+
+var ErrLoginThrottled = errors.New("too many failed logins")
+
+// LoginAttempt records one try at logging in, successful or not. Attempts
+// for usernames that don't exist have no user or clinic group.
+type LoginAttempt struct {
+	gorm.Model
+	Username      string `json:"username" gorm:"index"`
+	UserID        *uint  `json:"user_id"`
+	ClinicGroupID *uint  `json:"clinic_group_id" gorm:"index"`
+	IPAddress     string `json:"ip_address" gorm:"index"`
+	UserAgent     string `json:"user_agent"`
+	Success       bool   `json:"success"`
+	Reason        string `json:"reason"` // why it failed, one of the LoginFailed constants
+}
+
+func normalizeLoginUsername(username string) string {
+	username = strings.TrimSpace(username)
+	if len(username) > 255 {
+		username = username[:255]
+	}
+	return username
+}
+
+// RecordLoginAttempt stores a login attempt, linking it to the user when the
+// username exists.
+func RecordLoginAttempt(username string, ipAddress string, userAgent string, success bool, reason string) error {
+	attempt := LoginAttempt{
+		Username:  normalizeLoginUsername(username),
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   success,
+		Reason:    reason,
+	}
+	var user User
+	if err := DB.Select("id", "clinic_group_id").Where("username = ?", attempt.Username).Take(&user).Error; err == nil {
+		attempt.UserID = &user.ID
+		attempt.ClinicGroupID = &user.ClinicGroupID
+	}
+	return DB.Create(&attempt).Error
+}
+
+// failedLogins counts the failures matched by query within the window and
+// returns the time of the latest one. Blocked attempts don't count, so
+// retrying while locked out doesn't extend the lockout.
+func failedLogins(query string, value interface{}, since time.Time) (int64, time.Time, error) {
+	var result struct {
+		Count int64
+		Last  *time.Time
+	}
+	err := DB.Model(&LoginAttempt{}).
+		Select("COUNT(*) AS count, MAX(created_at) AS last").
+		Where(query, value).
+		Where("success = ? AND reason <> ? AND created_at > ?", false, LoginFailedLocked, since).
+		Scan(&result).Error
+	if err != nil || result.Last == nil {
+		return 0, time.Time{}, err
+	}
+	return result.Count, *result.Last, nil
+}
+
+// CheckLoginThrottle fails with ErrLoginThrottled while the username or the
+// IP address has too many recent failed logins. The returned time is when
+// logins are allowed again. A successful login clears the username's count.
+func CheckLoginThrottle(username string, ipAddress string) (time.Time, error) {
+	now := time.Now()
+	username = normalizeLoginUsername(username)
+
+	since := now.Add(-LoginAttemptWindow)
+	var lastSuccess LoginAttempt
+	if err := DB.Select("created_at").Where("username = ? AND success = ?", username, true).
+		Order("created_at DESC").Take(&lastSuccess).Error; err == nil && lastSuccess.CreatedAt.After(since) {
+		since = lastSuccess.CreatedAt
+	}
+
+	count, last, err := failedLogins("username = ?", username, since)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if count >= MaxFailedLoginsPerUser && now.Before(last.Add(LoginLockout)) {
+		return last.Add(LoginLockout), ErrLoginThrottled
+	}
+
+	count, last, err = failedLogins("ip_address = ?", ipAddress, now.Add(-LoginAttemptWindow))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if count >= MaxFailedLoginsPerIP && now.Before(last.Add(LoginLockout)) {
+		return last.Add(LoginLockout), ErrLoginThrottled
+	}
+	return time.Time{}, nil
+}
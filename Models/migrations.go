@@ -0,0 +1,172 @@
+package Models
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var legacyDateTimeTables = []string{"time_blocks", "appointments", "appointment_requests"}
+
+const legacyDateTimePattern = `^\d{4}/\d{1,2}/\d{1,2} & \d{1,2}:\d{2} (AM|PM)$`
+
+// migrateLegacyDateTimes converts the old text date_time columns to
+// timestamptz. It is a no-op once the columns have been converted. The text
+// is copied to date_time_legacy first, so rows that don't match the legacy
+// format keep their value there when date_time is set to NULL.
+func migrateLegacyDateTimes() {
+	zone := strings.ReplaceAll(DefaultLocation.String(), "'", "''")
+	if zone == "Local" {
+		zone = "UTC"
+	}
+
+	for _, table := range legacyDateTimeTables {
+		var dataType string
+		if err := DB.Raw("SELECT data_type FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = 'date_time'", table).Scan(&dataType).Error; err != nil {
+			log.Printf("Failed to inspect %s.date_time: %v", table, err)
+			continue
+		}
+		if dataType != "text" && dataType != "character varying" {
+			continue
+		}
+
+		var unmatched int64
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS date_time_legacy text", table)).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(fmt.Sprintf("UPDATE %s SET date_time_legacy = date_time WHERE date_time IS NOT NULL", table)).Error; err != nil {
+				return err
+			}
+			if err := tx.Raw(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE date_time IS NOT NULL AND date_time !~ ?", table), legacyDateTimePattern).Scan(&unmatched).Error; err != nil {
+				return err
+			}
+			return tx.Exec(fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN date_time TYPE timestamptz USING
+				CASE WHEN date_time ~ '%s'
+				THEN to_timestamp(date_time, 'YYYY/MM/DD & HH12:MI AM')::timestamp AT TIME ZONE '%s'
+				ELSE NULL END`, table, legacyDateTimePattern, zone)).Error
+		})
+		if err != nil {
+			log.Printf("Failed to migrate %s.date_time to timestamptz: %v", table, err)
+			continue
+		}
+		log.Printf("Migrated %s.date_time to timestamptz", table)
+		if unmatched > 0 {
+			log.Printf("%d %s rows had a date_time in an unknown format, their text is kept in date_time_legacy", unmatched, table)
+		}
+	}
+}
+
+// removeDuplicateAvailableTimeBlocks soft deletes available blocks that share
+// a slot with another block so the unique slot index can be created. Booked
+// duplicates are left alone and logged, and the index migration stops the
+// server from starting until they are resolved.
+func removeDuplicateAvailableTimeBlocks() {
+	if !DB.Migrator().HasTable(&TimeBlock{}) {
+		return
+	}
+	result := DB.Exec(`UPDATE time_blocks SET deleted_at = NOW() WHERE id IN (
+		SELECT id FROM (
+			SELECT id, is_available, ROW_NUMBER() OVER (PARTITION BY schedule_id, date_time ORDER BY is_available ASC, id ASC) AS position
+			FROM time_blocks WHERE deleted_at IS NULL AND date_time IS NOT NULL
+		) ranked WHERE ranked.position > 1 AND ranked.is_available
+	)`)
+	if result.Error != nil {
+		log.Printf("Failed to remove duplicate time blocks: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Removed %d duplicate available time blocks", result.RowsAffected)
+	}
+
+	var duplicates []struct {
+		ScheduleID uint
+		DateTime   DateTime
+		Count      int
+	}
+	if err := DB.Raw(`SELECT schedule_id, date_time, COUNT(*) AS count FROM time_blocks
+		WHERE deleted_at IS NULL AND date_time IS NOT NULL
+		GROUP BY schedule_id, date_time HAVING COUNT(*) > 1`).Scan(&duplicates).Error; err != nil {
+		log.Printf("Failed to look for booked duplicate time blocks: %v", err)
+		return
+	}
+	for _, duplicate := range duplicates {
+		log.Printf("Schedule %d has %d booked time blocks at %s", duplicate.ScheduleID, duplicate.Count, duplicate.DateTime)
+	}
+}
+
+// dropLegacyPatientOTP removes the old plain text OTP column now that codes
+// are stored hashed.
+func dropLegacyPatientOTP() {
+	if !DB.Migrator().HasColumn(&Patient{}, "otp") {
+		return
+	}
+	if err := DB.Migrator().DropColumn(&Patient{}, "otp"); err != nil {
+		log.Printf("Failed to drop patients.otp: %v", err)
+	}
+}
+
+// backfillClinicGroupSlugs gives clinic groups created before slugs existed
+// one derived from their name.
+func backfillClinicGroupSlugs() {
+	var groups []ClinicGroup
+	if err := DB.Where("slug = '' OR slug IS NULL").Find(&groups).Error; err != nil {
+		log.Printf("Failed to load clinic groups without a slug: %v", err)
+		return
+	}
+	for _, group := range groups {
+		slug, err := UniqueClinicGroupSlug(DB, group.Name)
+		if err != nil {
+			log.Printf("Failed to derive a slug for clinic group %d: %v", group.ID, err)
+			continue
+		}
+		if err := DB.Model(&group).Update("slug", slug).Error; err != nil {
+			log.Printf("Failed to set the slug of clinic group %d: %v", group.ID, err)
+		}
+	}
+}
+
+// backfillClinicGroupIDs copies the clinic group onto treatment plans and
+// therapists created before they carried it, from their patient and user.
+func backfillClinicGroupIDs() {
+	statements := map[string]string{
+		"treatment_plans": `UPDATE treatment_plans SET clinic_group_id = patients.clinic_group_id FROM patients
+			WHERE patients.id = treatment_plans.patient_id AND COALESCE(treatment_plans.clinic_group_id, 0) = 0`,
+		"therapists": `UPDATE therapists SET clinic_group_id = users.clinic_group_id FROM users
+			WHERE users.id = therapists.user_id AND COALESCE(therapists.clinic_group_id, 0) = 0`,
+	}
+	for table, statement := range statements {
+		result := DB.Exec(statement)
+		if result.Error != nil {
+			log.Printf("Failed to backfill %s.clinic_group_id: %v", table, result.Error)
+			continue
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Backfilled clinic_group_id on %d %s", result.RowsAffected, table)
+		}
+	}
+}
+
+// backfillUserRoles gives users created before roles existed the role
+// matching their permission number.
+func backfillUserRoles() {
+	for _, permission := range []int{2, 3} {
+		role := RoleForLegacyPermission(permission)
+		query := DB.Model(&User{}).Where("COALESCE(role, '') = ''")
+		if role == RoleOwner {
+			query = query.Where("permission >= ?", permission)
+		} else {
+			query = query.Where("permission = ?", permission)
+		}
+		result := query.Update("role", role)
+		if result.Error != nil {
+			log.Printf("Failed to backfill %s roles: %v", role, result.Error)
+			continue
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Backfilled the %s role on %d users", role, result.RowsAffected)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package Models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OTPLength         = 6
+	OTPTTL            = 10 * time.Minute
+	OTPMaxAttempts    = 5
+	OTPLockout        = 30 * time.Minute
+	OTPResendInterval = time.Minute
+)
+
+var (
+	ErrOTPInvalid       = errors.New("incorrect OTP")
+	ErrOTPExpired       = errors.New("OTP expired")
+	ErrOTPLocked        = errors.New("too many OTP attempts")
+	ErrOTPResendTooSoon = errors.New("OTP was sent recently")
+)
+
+// generateOTPCode returns a random numeric code of count digits.
+func generateOTPCode(count int) (string, error) {
+	code := make([]byte, count)
+	for index := range code {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[index] = byte('0' + digit.Int64())
+	}
+	return string(code), nil
+}
+
+// GenerateOTPToken creates a new numeric code of count digits, stores its
+// hash with an expiry on the patient and returns the code to send. Wrong
+// guesses at the previous code still count towards OTPMaxAttempts.
+func (patient *Patient) GenerateOTPToken(count int) (string, error) {
+	code, err := generateOTPCode(count)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(OTPTTL)
+	patient.OTPHash = HashToken(code)
+	patient.OTPExpiresAt = &expiresAt
+	patient.OTPSentAt = &now
+	return code, nil
+}
+
+// lockPatient loads the patient row with FOR UPDATE so concurrent OTP checks
+// can't race on the attempt counter.
+func lockPatient(tx *gorm.DB, patientID uint) (Patient, error) {
+	var patient Patient
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&patient, patientID).Error
+	return patient, err
+}
+
+// IssuePatientOTP replaces the patient's code with a new one and returns it.
+// It fails with ErrOTPResendTooSoon within OTPResendInterval of the last code
+// and with ErrOTPLocked while the patient is locked out. The returned time is
+// when the next code can be requested.
+func IssuePatientOTP(db *gorm.DB, patientID uint) (string, time.Time, error) {
+	var code string
+	var retryAt time.Time
+	err := db.Transaction(func(tx *gorm.DB) error {
+		patient, err := lockPatient(tx, patientID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if patient.OTPLockedUntil != nil && now.Before(*patient.OTPLockedUntil) {
+			retryAt = *patient.OTPLockedUntil
+			return ErrOTPLocked
+		}
+		if patient.OTPSentAt != nil && now.Before(patient.OTPSentAt.Add(OTPResendInterval)) {
+			retryAt = patient.OTPSentAt.Add(OTPResendInterval)
+			return ErrOTPResendTooSoon
+		}
+
+		// Attempts only start over once a lockout is over, so resending
+		// doesn't buy more guesses
+		if patient.OTPLockedUntil != nil {
+			patient.OTPAttempts = 0
+			patient.OTPLockedUntil = nil
+		}
+
+		if code, err = patient.GenerateOTPToken(OTPLength); err != nil {
+			return err
+		}
+		retryAt = patient.OTPSentAt.Add(OTPResendInterval)
+		return tx.Model(&patient).
+			Select("otp_hash", "otp_expires_at", "otp_attempts", "otp_sent_at", "otp_locked_until").
+			Updates(&patient).Error
+	})
+	return code, retryAt, err
+}
+
+// VerifyPatientOTP checks code against the patient's current one and marks
+// the patient verified on success. Every wrong guess counts towards
+// OTPMaxAttempts, after which the code is discarded and the patient is locked
+// out for OTPLockout. The returned number is the attempts left.
+func VerifyPatientOTP(db *gorm.DB, patientID uint, code string) (int, error) {
+	var attemptsLeft int
+	var verdict error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		patient, err := lockPatient(tx, patientID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if patient.OTPLockedUntil != nil && now.Before(*patient.OTPLockedUntil) {
+			verdict = ErrOTPLocked
+			return nil
+		}
+		if patient.OTPHash == "" || patient.OTPExpiresAt == nil || !now.Before(*patient.OTPExpiresAt) {
+			verdict = ErrOTPExpired
+			return nil
+		}
+
+		if subtle.ConstantTimeCompare([]byte(HashToken(code)), []byte(patient.OTPHash)) == 1 {
+			return tx.Model(&patient).Updates(map[string]interface{}{
+				"is_verified":      true,
+				"otp_hash":         "",
+				"otp_expires_at":   nil,
+				"otp_attempts":     0,
+				"otp_locked_until": nil,
+			}).Error
+		}
+
+		// Wrong guesses are committed, so they aren't undone with the verdict
+		updates := map[string]interface{}{"otp_attempts": patient.OTPAttempts + 1}
+		attemptsLeft = OTPMaxAttempts - int(patient.OTPAttempts) - 1
+		verdict = ErrOTPInvalid
+		if attemptsLeft <= 0 {
+			attemptsLeft = 0
+			updates["otp_hash"] = ""
+			updates["otp_expires_at"] = nil
+			updates["otp_locked_until"] = now.Add(OTPLockout)
+			verdict = ErrOTPLocked
+		}
+		return tx.Model(&patient).Updates(updates).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return attemptsLeft, verdict
+}
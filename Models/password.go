@@ -0,0 +1,191 @@
+package Models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MinPasswordLength = 8
+	// bcrypt ignores everything past 72 bytes
+	MaxPasswordLength = 72
+
+	temporaryPasswordLength = 12
+)
+
+var (
+	ErrPasswordLength    = errors.New("password must be 8 to 72 characters")
+	ErrPasswordIncorrect = errors.New("current password is incorrect")
+)
+
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrPasswordLength
+	}
+	return nil
+}
+
+// setPassword stores a new password for the user and ends their sessions,
+// except keepSessionID when it isn't zero.
+func setPassword(tx *gorm.DB, userID uint, password string, mustChange bool, keepSessionID uint) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	result := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"password":                    string(hashedPassword),
+		"must_change_password":        mustChange,
+		"password_reset_hash":         "",
+		"password_reset_expires_at":   nil,
+		"password_reset_attempts":     0,
+		"password_reset_locked_until": nil,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return RevokeOtherUserSessions(tx, userID, keepSessionID)
+}
+
+// ChangePassword replaces the user's password after checking the current
+// one. Their other sessions are logged out.
+func ChangePassword(db *gorm.DB, userID uint, sessionID uint, currentPassword string, newPassword string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Select("id", "password").First(&user, userID).Error; err != nil {
+			return err
+		}
+		if err := VerifyPassword(currentPassword, user.Password); err != nil {
+			return ErrPasswordIncorrect
+		}
+		return setPassword(tx, userID, newPassword, false, sessionID)
+	})
+}
+
+// ResetPassword gives the user a random temporary password and logs them out
+// everywhere. They are asked to change it on their next login.
+func ResetPassword(db *gorm.DB, userID uint) (string, error) {
+	password, err := generateTemporaryPassword()
+	if err != nil {
+		return "", err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return setPassword(tx, userID, password, true, 0)
+	})
+	return password, err
+}
+
+func generateTemporaryPassword() (string, error) {
+	password := make([]byte, temporaryPasswordLength)
+	for index := range password {
+		char, err := rand.Int(rand.Reader, big.NewInt(int64(len(recoveryAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		password[index] = recoveryAlphabet[char.Int64()]
+	}
+	return string(password), nil
+}
+
+// lockUser loads the user row with FOR UPDATE so concurrent reset code checks
+// can't race on the attempt counter.
+func lockUser(tx *gorm.DB, userID uint) (User, error) {
+	var user User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error
+	return user, err
+}
+
+// IssuePasswordResetCode replaces the user's reset code with a new one and
+// returns it. It follows the rules of IssuePatientOTP, wrong guesses carry
+// over to the new code until a lockout is over.
+func IssuePasswordResetCode(db *gorm.DB, userID uint) (string, time.Time, error) {
+	var code string
+	var retryAt time.Time
+	err := db.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if user.PasswordResetLockedUntil != nil && now.Before(*user.PasswordResetLockedUntil) {
+			retryAt = *user.PasswordResetLockedUntil
+			return ErrOTPLocked
+		}
+		if user.PasswordResetSentAt != nil && now.Before(user.PasswordResetSentAt.Add(OTPResendInterval)) {
+			retryAt = user.PasswordResetSentAt.Add(OTPResendInterval)
+			return ErrOTPResendTooSoon
+		}
+
+		if code, err = generateOTPCode(OTPLength); err != nil {
+			return err
+		}
+		retryAt = now.Add(OTPResendInterval)
+		updates := map[string]interface{}{
+			"password_reset_hash":       HashToken(code),
+			"password_reset_expires_at": now.Add(OTPTTL),
+			"password_reset_sent_at":    now,
+		}
+		if user.PasswordResetLockedUntil != nil {
+			updates["password_reset_attempts"] = 0
+			updates["password_reset_locked_until"] = nil
+		}
+		return tx.Model(&User{}).Where("id = ?", userID).Updates(updates).Error
+	})
+	return code, retryAt, err
+}
+
+// ResetPasswordWithCode sets a new password when code matches the user's
+// reset code and logs them out everywhere. Wrong guesses count towards
+// OTPMaxAttempts like VerifyPatientOTP. The returned number is the attempts
+// left.
+func ResetPasswordWithCode(db *gorm.DB, userID uint, code string, newPassword string) (int, error) {
+	var attemptsLeft int
+	var verdict error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		user, err := lockUser(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if user.PasswordResetLockedUntil != nil && now.Before(*user.PasswordResetLockedUntil) {
+			verdict = ErrOTPLocked
+			return nil
+		}
+		if user.PasswordResetHash == "" || user.PasswordResetExpiresAt == nil || !now.Before(*user.PasswordResetExpiresAt) {
+			verdict = ErrOTPExpired
+			return nil
+		}
+
+		if subtle.ConstantTimeCompare([]byte(HashToken(code)), []byte(user.PasswordResetHash)) == 1 {
+			return setPassword(tx, userID, newPassword, false, 0)
+		}
+
+		// Wrong guesses are committed, so they aren't undone with the verdict
+		updates := map[string]interface{}{"password_reset_attempts": user.PasswordResetAttempts + 1}
+		attemptsLeft = OTPMaxAttempts - int(user.PasswordResetAttempts) - 1
+		verdict = ErrOTPInvalid
+		if attemptsLeft <= 0 {
+			attemptsLeft = 0
+			updates["password_reset_hash"] = ""
+			updates["password_reset_expires_at"] = nil
+			updates["password_reset_locked_until"] = now.Add(OTPLockout)
+			verdict = ErrOTPLocked
+		}
+		return tx.Model(&User{}).Where("id = ?", userID).Updates(updates).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return attemptsLeft, verdict
+}
@@ -0,0 +1,112 @@
+package Models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNoRoomAvailable      = errors.New("no room is free at this time")
+	ErrNoEquipmentAvailable = errors.New("no equipment of the required type is free at this time")
+)
+
+// Rooms and equipment without a branch are shared by every branch
+type Room struct {
+	gorm.Model
+	Name          string `json:"name"`
+	ClinicGroupID uint   `json:"clinic_group_id" gorm:"index"`
+	BranchID      *uint  `json:"branch_id" gorm:"index;default:null"`
+}
+
+type Equipment struct {
+	gorm.Model
+	Name          string `json:"name"`
+	Type          string `json:"type" gorm:"index"` // e.g. "shockwave", "laser"
+	ClinicGroupID uint   `json:"clinic_group_id" gorm:"index"`
+	BranchID      *uint  `json:"branch_id" gorm:"index;default:null"`
+}
+
+// ResourceRequirement describes what a session needs besides the therapist.
+type ResourceRequirement struct {
+	Room          bool
+	EquipmentType string
+}
+
+func (plan SuperTreatmentPlan) Resources() ResourceRequirement {
+	return ResourceRequirement{Room: plan.RequiresRoom, EquipmentType: plan.RequiredEquipmentType}
+}
+
+// TimeBlockResources returns the requirement met by the resources already
+// held by a block, so a moved session keeps needing the same kind.
+func TimeBlockResources(db *gorm.DB, timeBlock TimeBlock) (ResourceRequirement, error) {
+	requirement := ResourceRequirement{Room: timeBlock.RoomID != nil}
+	if timeBlock.EquipmentID != nil {
+		var equipment Equipment
+		if err := db.Unscoped().Select("id", "type").First(&equipment, *timeBlock.EquipmentID).Error; err != nil {
+			return requirement, err
+		}
+		requirement.EquipmentType = equipment.Type
+	}
+	return requirement, nil
+}
+
+// AllocateResources picks a room and a piece of equipment of the branch, or
+// shared by every branch, that are free for the whole slot and stores them on
+// it. The candidates are locked for the rest of tx so concurrent bookings
+// can't pick the same one.
+func AllocateResources(tx *gorm.DB, clinicGroupID uint, branchID *uint, slot *Slot, requirement ResourceRequirement) error {
+	slot.RoomID, slot.EquipmentID = nil, nil
+
+	if requirement.Room {
+		var locked []uint
+		if err := tx.Model(&Room{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("clinic_group_id = ?", clinicGroupID).Scopes(InBranch(branchID)).Order("id").Pluck("id", &locked).Error; err != nil {
+			return err
+		}
+
+		var room Room
+		err := tx.Model(&Room{}).
+			Where("clinic_group_id = ?", clinicGroupID).Scopes(InBranch(branchID)).
+			Where("NOT EXISTS (?)", busyResourceBlocks(tx, *slot).Where("time_blocks.room_id = rooms.id")).
+			Order("id").First(&room).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNoRoomAvailable
+		}
+		if err != nil {
+			return err
+		}
+		slot.RoomID = &room.ID
+	}
+
+	if requirement.EquipmentType != "" {
+		var locked []uint
+		if err := tx.Model(&Equipment{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("clinic_group_id = ? AND type = ?", clinicGroupID, requirement.EquipmentType).Scopes(InBranch(branchID)).Order("id").Pluck("id", &locked).Error; err != nil {
+			return err
+		}
+
+		var equipment Equipment
+		err := tx.Model(&Equipment{}).
+			Where("clinic_group_id = ? AND type = ?", clinicGroupID, requirement.EquipmentType).Scopes(InBranch(branchID)).
+			Where("NOT EXISTS (?)", busyResourceBlocks(tx, *slot).Where("time_blocks.equipment_id = equipment.id")).
+			Order("id").First(&equipment).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNoEquipmentAvailable
+		}
+		if err != nil {
+			return err
+		}
+		slot.EquipmentID = &equipment.ID
+	}
+	return nil
+}
+
+// busyResourceBlocks selects booked blocks of any schedule that overlap the
+// slot; callers narrow it down to one resource.
+func busyResourceBlocks(tx *gorm.DB, slot Slot) *gorm.DB {
+	return tx.Session(&gorm.Session{NewDB: true}).Model(&TimeBlock{}).Select("1").
+		Where("time_blocks.is_available = ?", false).
+		Where("time_blocks.date_time < ? AND time_blocks.date_time + (time_blocks.duration_minutes + time_blocks.buffer_minutes) * INTERVAL '1 minute' > ?", slot.BusyUntil(), slot.DateTime)
+}
@@ -0,0 +1,86 @@
+package Models
+
+// Role is the job of a staff user inside their clinic group. What a role may
+// do is fixed by rolePermissions.
+type Role string
+
+const (
+	RoleOwner      Role = "owner"
+	RoleSecretary  Role = "secretary"
+	RoleTherapist  Role = "therapist"
+	RoleAccountant Role = "accountant"
+)
+
+// Permission grants access to one group of protected routes.
+type Permission string
+
+const (
+	PermissionSchedule       Permission = "schedule"        // the therapist's own schedule, working hours and calendar feed
+	PermissionAppointments   Permission = "appointments"    // booking, requests, waitlist and group sessions
+	PermissionAvailability   Permission = "availability"    // leave, closures and session settings of every therapist
+	PermissionPatients       Permission = "patients"        // patient details and records
+	PermissionDeletePatients Permission = "patients:delete" // removing patients and their bookings
+	PermissionBilling        Permission = "billing"         // packages, payments and referrals
+	PermissionReports        Permission = "reports"         // exports and branch reports
+	PermissionClinic         Permission = "clinic"          // clinic settings, branches, resources, treatments and therapists
+	PermissionStaff          Permission = "staff"           // assigning roles
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermissionSchedule, PermissionAppointments, PermissionAvailability, PermissionPatients,
+		PermissionDeletePatients, PermissionBilling, PermissionReports, PermissionClinic, PermissionStaff,
+	},
+	RoleSecretary: {
+		PermissionAppointments, PermissionAvailability, PermissionPatients, PermissionDeletePatients, PermissionBilling,
+	},
+	RoleTherapist: {
+		PermissionSchedule, PermissionAppointments, PermissionPatients,
+	},
+	RoleAccountant: {
+		PermissionBilling, PermissionReports,
+	},
+}
+
+// Roles lists every role in the order they are shown.
+var Roles = []Role{RoleOwner, RoleSecretary, RoleTherapist, RoleAccountant}
+
+func (role Role) Valid() bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func (role Role) Permissions() []Permission {
+	return rolePermissions[role]
+}
+
+func (role Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// LegacyPermission is the number older clients read from User.Permission.
+func (role Role) LegacyPermission() int {
+	switch role {
+	case RoleOwner:
+		return 3
+	case "":
+		return 0
+	}
+	return 2
+}
+
+// RoleForLegacyPermission maps the numbers users had before roles existed.
+func RoleForLegacyPermission(permission int) Role {
+	switch {
+	case permission >= 3:
+		return RoleOwner
+	case permission == 2:
+		return RoleTherapist
+	}
+	return ""
+}
@@ -0,0 +1,293 @@
+package Models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"PhysioUp/Utils/Token"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSessionInvalid = errors.New("session is invalid, revoked or expired")
+	ErrUserFrozen     = errors.New("user is frozen")
+)
+
+// UserSession is one login of a staff user. It holds the hash of the current
+// refresh token, which is replaced on every refresh, and the hash of the one
+// before so a stolen token that is replayed after rotation ends the session.
+type UserSession struct {
+	gorm.Model
+	UserID            uint       `json:"user_id" gorm:"index"`
+	RefreshTokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	PreviousTokenHash string     `json:"-" gorm:"index"`
+	DeviceToken       string     `json:"-"`        // FCM token registered through this session
+	Platform          string     `json:"platform"` // android, ios, web or unknown
+	UserAgent         string     `json:"user_agent"`
+	IPAddress         string     `json:"ip_address"`
+	LastUsedAt        time.Time  `json:"last_used_at"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+}
+
+func (session *UserSession) Active() bool {
+	return session.RevokedAt == nil && time.Now().Before(session.ExpiresAt)
+}
+
+// CreateUserSession starts a session for the user and returns it with its
+// refresh token.
+func CreateUserSession(db *gorm.DB, userID uint, userAgent string, ipAddress string) (UserSession, string, error) {
+	lifespan, err := Token.RefreshTokenLifespan()
+	if err != nil {
+		return UserSession{}, "", err
+	}
+	refreshToken, hash, err := GenerateSecureToken()
+	if err != nil {
+		return UserSession{}, "", err
+	}
+
+	now := time.Now()
+	session := UserSession{
+		UserID:           userID,
+		RefreshTokenHash: hash,
+		Platform:         PlatformFromUserAgent(userAgent),
+		UserAgent:        userAgent,
+		IPAddress:        ipAddress,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(lifespan),
+	}
+	err = db.Create(&session).Error
+	return session, refreshToken, err
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one and extends its
+// session. It fails with ErrSessionInvalid for unknown, revoked and expired
+// tokens, revoking the session when an already rotated token is replayed,
+// and with ErrUserFrozen once the user is frozen.
+func RotateRefreshToken(refreshToken string, ipAddress string) (UserSession, string, error) {
+	var session UserSession
+	var newToken string
+	replayed := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		hash := HashToken(refreshToken)
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("refresh_token_hash = ?", hash).First(&session).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Where("previous_token_hash = ? AND revoked_at IS NULL", hash).First(&session).Error; err == nil {
+				replayed = true
+			}
+			return ErrSessionInvalid
+		}
+		if err != nil {
+			return err
+		}
+		if !session.Active() {
+			return ErrSessionInvalid
+		}
+
+		var user User
+		if err := tx.Select("id", "is_frozen").First(&user, session.UserID).Error; err != nil {
+			return ErrSessionInvalid
+		}
+		if user.IsFrozen {
+			return ErrUserFrozen
+		}
+
+		lifespan, err := Token.RefreshTokenLifespan()
+		if err != nil {
+			return err
+		}
+		var newHash string
+		if newToken, newHash, err = GenerateSecureToken(); err != nil {
+			return err
+		}
+		now := time.Now()
+		session.PreviousTokenHash = session.RefreshTokenHash
+		session.RefreshTokenHash = newHash
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(lifespan)
+		session.IPAddress = ipAddress
+		return tx.Model(&session).
+			Select("previous_token_hash", "refresh_token_hash", "last_used_at", "expires_at", "ip_address").
+			Updates(&session).Error
+	})
+	if replayed {
+		if err := RevokeUserSession(DB, session.ID); err != nil {
+			return session, "", err
+		}
+	}
+	return session, newToken, err
+}
+
+// SessionActive reports whether the access tokens of a session are still
+// accepted.
+func SessionActive(sessionID uint, userID uint) bool {
+	var session UserSession
+	if err := DB.Select("id", "revoked_at", "expires_at").Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return false
+	}
+	return session.Active()
+}
+
+// revokeSessions ends the sessions matched by query and removes the device
+// tokens registered through them, so the devices stop getting notifications.
+func revokeSessions(db *gorm.DB, query func(*gorm.DB) *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var deviceTokens []string
+		if err := tx.Model(&UserSession{}).Scopes(query).
+			Where("revoked_at IS NULL AND device_token <> ''").Pluck("device_token", &deviceTokens).Error; err != nil {
+			return err
+		}
+		if len(deviceTokens) > 0 {
+			if err := tx.Unscoped().Where("value IN ?", deviceTokens).Delete(&DeviceToken{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&UserSession{}).Scopes(query).Where("revoked_at IS NULL").
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "device_token": ""}).Error
+	})
+}
+
+// Platforms a session can report
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+	PlatformWeb     = "web"
+	PlatformUnknown = "unknown"
+)
+
+// ValidPlatform reports whether the app sent a platform we know.
+func ValidPlatform(platform string) bool {
+	switch platform {
+	case PlatformAndroid, PlatformIOS, PlatformWeb:
+		return true
+	}
+	return false
+}
+
+// PlatformFromUserAgent guesses the platform of a new session. SaveFCM
+// corrects it when the app says which platform it runs on.
+func PlatformFromUserAgent(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(userAgent, "android") || strings.Contains(userAgent, "okhttp"):
+		return PlatformAndroid
+	case strings.Contains(userAgent, "iphone") || strings.Contains(userAgent, "ipad") ||
+		strings.Contains(userAgent, "ios") || strings.Contains(userAgent, "darwin"):
+		return PlatformIOS
+	case strings.Contains(userAgent, "mozilla"):
+		return PlatformWeb
+	}
+	return PlatformUnknown
+}
+
+// AttachDeviceToken ties a device token to the session it was registered
+// through, taking it off any older session of the same device. A token the
+// session had before is replaced, so it is removed.
+func AttachDeviceToken(sessionID uint, deviceToken string, platform string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var session UserSession
+		if err := tx.Select("id", "device_token").First(&session, sessionID).Error; err != nil {
+			return err
+		}
+		if session.DeviceToken != "" && session.DeviceToken != deviceToken {
+			if err := tx.Unscoped().Where("value = ?", session.DeviceToken).Delete(&DeviceToken{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&UserSession{}).Where("device_token = ? AND id <> ?", deviceToken, sessionID).
+			Update("device_token", "").Error; err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"device_token": deviceToken}
+		if ValidPlatform(platform) {
+			updates["platform"] = platform
+		}
+		return tx.Model(&UserSession{}).Where("id = ?", sessionID).Updates(updates).Error
+	})
+}
+
+// ActiveUserSessions lists the sessions the user is logged in with, most
+// recently used first.
+func ActiveUserSessions(db *gorm.DB, userID uint) ([]UserSession, error) {
+	sessions := []UserSession{}
+	err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+func RevokeUserSession(db *gorm.DB, sessionID uint) error {
+	return revokeSessions(db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", sessionID)
+	})
+}
+
+// RevokeOtherUserSessions logs the user out everywhere but the session they
+// are using.
+func RevokeOtherUserSessions(db *gorm.DB, userID uint, keepSessionID uint) error {
+	return revokeSessions(db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? AND id <> ?", userID, keepSessionID)
+	})
+}
+
+// RevokeAllUserSessions logs the user out everywhere.
+func RevokeAllUserSessions(db *gorm.DB, userID uint) error {
+	return revokeSessions(db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID)
+	})
+}
+
+// SetUserFrozen freezes or unfreezes a user. Freezing ends all their sessions.
+func SetUserFrozen(db *gorm.DB, userID uint, frozen bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userID).Update("is_frozen", frozen)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if !frozen {
+			return nil
+		}
+		return RevokeAllUserSessions(tx, userID)
+	})
+}
+
+// PruneDeviceTokens removes device tokens Firebase no longer delivers to.
+func PruneDeviceTokens(tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("value IN ?", tokens).Delete(&DeviceToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&UserSession{}).Where("device_token IN ?", tokens).Update("device_token", "").Error
+	})
+}
+
+// PruneStaleSessions ends the sessions that expired without logging out,
+// which removes their device tokens, and removes tokens no session uses
+// that haven't been saved again for longer than a session lasts.
+func PruneStaleSessions() error {
+	now := time.Now()
+	if err := revokeSessions(DB, func(db *gorm.DB) *gorm.DB {
+		return db.Where("expires_at <= ?", now)
+	}); err != nil {
+		return err
+	}
+
+	lifespan, err := Token.RefreshTokenLifespan()
+	if err != nil {
+		return err
+	}
+	return DB.Unscoped().
+		Where("(updated_at < ? OR deleted_at IS NOT NULL)", now.Add(-lifespan)).
+		Where("value NOT IN (?)", DB.Model(&UserSession{}).Select("device_token").
+			Where("revoked_at IS NULL AND device_token <> ''")).
+		Delete(&DeviceToken{}).Error
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
@@ -35,35 +36,85 @@ func ConnectDataBase() {
 	DbName := os.Getenv("DB_NAME")
 	DbPort := os.Getenv("DB_PORT")
 
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable", DbHost, DbUser, DbPassword, DbName, DbPort)
-	_ = dsn
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if zone := os.Getenv("TIME_ZONE"); zone != "" {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			log.Fatalf("Invalid TIME_ZONE %q: %v", zone, err)
+		}
+		DefaultLocation = location
+	}
 
-	if err != nil {
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable", DbHost, DbUser, DbPassword, DbName, DbPort)
+	if err := OpenDataBase(dsn); err != nil {
 		fmt.Println("Cannot connect to database ")
 		log.Fatal("connection error:", err)
-	} else {
-		fmt.Println("We are connected to the database ")
 	}
+	fmt.Println("We are connected to the database ")
+	MigrateDataBase()
+}
+
+// OpenDataBase connects DB to the Postgres database at dsn and registers the
+// tenancy and audit callbacks. Tests use it with MigrateDataBase on their own
+// database.
+func OpenDataBase(dsn string) error {
+	var err error
+	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
+	if err != nil {
+		return err
+	}
+	if err := registerTenancyCallbacks(DB); err != nil {
+		return err
+	}
+	return registerAuditCallbacks(DB)
+}
+
+// MigrateDataBase creates and updates the tables of every model.
+func MigrateDataBase() {
+	// Convert legacy string columns before AutoMigrate touches them
+	migrateLegacyDateTimes()
+
 	// First migrate models with no dependencies
 	DB.AutoMigrate(&ClinicGroup{})
+	backfillClinicGroupSlugs()
+	DB.AutoMigrate(&Branch{})
 	DB.AutoMigrate(&SuperTreatmentPlan{})
 	DB.AutoMigrate(&DeviceToken{})
 
 	// Then migrate models that depend on the above
 	DB.AutoMigrate(&User{})
+	backfillUserRoles()
+	DB.AutoMigrate(&Invite{})
+	DB.AutoMigrate(&UserSession{})
+	DB.AutoMigrate(&RecoveryCode{})
+	DB.AutoMigrate(&LoginAttempt{})
+	DB.AutoMigrate(&AuditLog{})
 	DB.AutoMigrate(&Patient{})
+	dropLegacyPatientOTP()
 	DB.AutoMigrate(&Therapist{})
 
 	// Then migrate models that depend on the previous ones
 	DB.AutoMigrate(&TreatmentPlan{})
+	backfillClinicGroupIDs()
 	DB.AutoMigrate(&Schedule{})
+	DB.AutoMigrate(&WorkingHoursTemplate{})
+	DB.AutoMigrate(&BreakWindow{})
 
 	// Finally migrate models that depend on multiple other models
-	DB.AutoMigrate(&TimeBlock{})
+	removeDuplicateAvailableTimeBlocks()
+	// Double bookings are only prevented by the unique slot index, so booked
+	// duplicates it can't be created over have to be resolved first
+	if err := DB.AutoMigrate(&TimeBlock{}); err != nil {
+		log.Fatalf("Failed to migrate time_blocks, resolve booked time blocks sharing a slot: %v", err)
+	}
 	DB.AutoMigrate(&Referral{})
 	DB.AutoMigrate(&AppointmentRequest{})
 	DB.AutoMigrate(&Appointment{})
+	DB.AutoMigrate(&WaitlistEntry{})
+	DB.AutoMigrate(&WaitlistWindow{})
+	DB.AutoMigrate(&TherapistLeave{})
+	DB.AutoMigrate(&ClinicClosure{})
+	DB.AutoMigrate(&Room{})
+	DB.AutoMigrate(&Equipment{})
 	// var plan SuperTreatmentPlan = SuperTreatmentPlan{Description: "One Organ - 6 Sessions", SessionsCount: 6}
 	// DB.Save(&plan)
 	// DB.AutoMigrate(&DoctorWorkingHour{})
@@ -0,0 +1,119 @@
+package Models
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type clinicGroupKey struct{}
+
+// ScopedDB returns a session limited to one clinic group. Every query, update
+// and delete on a model with a ClinicGroupID field only sees that group's
+// rows, and every create or save stamps the field with it, so handlers can't
+// reach another clinic's data by ID. Raw and Exec SQL isn't scoped and has to
+// filter by clinic group itself.
+func ScopedDB(clinicGroupID uint) *gorm.DB {
+	return DB.WithContext(context.WithValue(context.Background(), clinicGroupKey{}, clinicGroupID))
+}
+
+// ScopedClinicGroupID returns the clinic group a session was scoped to with
+// ScopedDB.
+func ScopedClinicGroupID(db *gorm.DB) (uint, bool) {
+	if db.Statement.Context == nil {
+		return 0, false
+	}
+	clinicGroupID, ok := db.Statement.Context.Value(clinicGroupKey{}).(uint)
+	return clinicGroupID, ok
+}
+
+// tenantField returns the clinic group of a scoped statement and the field
+// holding it, or nil when the statement isn't scoped or its model isn't
+// tenant owned.
+func tenantField(db *gorm.DB) (uint, *schema.Field) {
+	clinicGroupID, ok := ScopedClinicGroupID(db)
+	if !ok || db.Statement.Schema == nil {
+		return 0, nil
+	}
+	field := db.Statement.Schema.LookUpField("ClinicGroupID")
+	if field == nil || field.DBName == "" {
+		return 0, nil
+	}
+	return clinicGroupID, field
+}
+
+// Models without a ClinicGroupID are scoped through the therapist owning them
+var tenantOwners = []struct {
+	Field    string
+	Subquery string
+}{
+	{"ScheduleID", "SELECT schedules.id FROM schedules JOIN therapists ON therapists.id = schedules.therapist_id WHERE therapists.clinic_group_id = ?"},
+	{"TherapistID", "SELECT therapists.id FROM therapists WHERE therapists.clinic_group_id = ?"},
+}
+
+func filterByClinicGroup(db *gorm.DB) {
+	if clinicGroupID, field := tenantField(db); field != nil {
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: clinicGroupID},
+		}})
+		return
+	}
+
+	clinicGroupID, ok := ScopedClinicGroupID(db)
+	if !ok || db.Statement.Schema == nil {
+		return
+	}
+	for _, owner := range tenantOwners {
+		field := db.Statement.Schema.LookUpField(owner.Field)
+		if field == nil || field.DBName == "" {
+			continue
+		}
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Expr{
+				SQL:  "? IN (" + owner.Subquery + ")",
+				Vars: []interface{}{clause.Column{Table: clause.CurrentTable, Name: field.DBName}, clinicGroupID},
+			},
+		}})
+		return
+	}
+}
+
+func stampClinicGroup(db *gorm.DB) {
+	clinicGroupID, field := tenantField(db)
+	if field == nil {
+		return
+	}
+	db.Statement.SetColumn(field.Name, clinicGroupID, true)
+
+	// Save falls back to an upsert when its update matches nothing, which
+	// must not overwrite another clinic's row either
+	if c, ok := db.Statement.Clauses["ON CONFLICT"]; ok {
+		if onConflict, ok := c.Expression.(clause.OnConflict); ok && !onConflict.DoNothing {
+			onConflict.Where.Exprs = append(onConflict.Where.Exprs,
+				clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: clinicGroupID})
+			db.Statement.AddClause(onConflict)
+		}
+	}
+}
+
+func registerTenancyCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Query().Before("gorm:query").Register("tenancy:query", filterByClinicGroup); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenancy:row", filterByClinicGroup); err != nil {
+		return err
+	}
+	if err := callbacks.Create().Before("gorm:create").Register("tenancy:create", stampClinicGroup); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenancy:update", func(db *gorm.DB) {
+		filterByClinicGroup(db)
+		stampClinicGroup(db)
+	}); err != nil {
+		return err
+	}
+	return callbacks.Delete().Before("gorm:delete").Register("tenancy:delete", filterByClinicGroup)
+}
@@ -1,6 +1,8 @@
 package Models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -14,20 +16,59 @@ type Therapist struct {
 	PhotoUrl            string               `json:"photo_url"`
 	IsDemo              bool                 `json:"is_demo"`
 	IsFrozen            bool                 `json:"is_frozen" gorm:"-"`
+	SessionMinutes      uint                 `json:"session_minutes" gorm:"default:30"`
+	BufferMinutes       uint                 `json:"buffer_minutes"` // kept free after each session
+	CalendarSecret      string               `json:"-"`              // signs the iCalendar feed URL
+	ClinicGroupID       uint                 `json:"clinic_group_id" gorm:"index"`
+	BranchID            *uint                `json:"branch_id" gorm:"index;default:null"` // nil when not tied to a branch
 }
 
 type Schedule struct {
 	gorm.Model
-	TherapistID uint
-	TimeBlocks  []TimeBlock `json:"time_blocks"`
+	TherapistID  uint
+	TimeBlocks   []TimeBlock            `json:"time_blocks"`
+	WorkingHours []WorkingHoursTemplate `json:"working_hours" gorm:"constraint:OnDelete:CASCADE;"`
 }
 
 type TimeBlock struct {
 	gorm.Model
-	ScheduleID  uint
-	DateTime    string      `json:"date"`
-	IsAvailable bool        `json:"is_available"`
-	Appointment Appointment `gorm:"constraint:OnDelete:CASCADE;" json:"appointment"`
+	ScheduleID      uint          `gorm:"uniqueIndex:idx_time_blocks_schedule_slot,priority:1,where:deleted_at IS NULL"`
+	DateTime        DateTime      `json:"date" gorm:"uniqueIndex:idx_time_blocks_schedule_slot,priority:2"`
+	IsAvailable     bool          `json:"is_available"`
+	DurationMinutes uint          `json:"duration_minutes" gorm:"default:30"`
+	BufferMinutes   uint          `json:"buffer_minutes"`
+	RoomID          *uint         `json:"room_id" gorm:"default:null"`
+	EquipmentID     *uint         `json:"equipment_id" gorm:"default:null"`
+	IsGroup         bool          `json:"is_group"`
+	Capacity        uint          `json:"capacity" gorm:"default:1"`
+	Appointment     Appointment   `gorm:"constraint:OnDelete:CASCADE;" json:"appointment"`
+	Appointments    []Appointment `gorm:"foreignKey:TimeBlockID;constraint:OnDelete:CASCADE;" json:"appointments"` // attendees of a group session
+}
+
+func (timeBlock *TimeBlock) AfterFind(tx *gorm.DB) error {
+	timeBlock.DateTime = timeBlock.DateTime.InLocation(ClinicGroupLocation(ScheduleClinicGroupID(timeBlock.ScheduleID)))
+	return nil
+}
+
+// End returns when the session in the block finishes.
+func (timeBlock TimeBlock) End() time.Time {
+	return timeBlock.DateTime.Add(time.Duration(timeBlock.DurationMinutes) * time.Minute)
+}
+
+// BusyUntil returns when the therapist is free again, including the buffer.
+func (timeBlock TimeBlock) BusyUntil() time.Time {
+	return timeBlock.End().Add(time.Duration(timeBlock.BufferMinutes) * time.Minute)
+}
+
+// Slot returns the interval the block occupies.
+func (timeBlock TimeBlock) Slot() Slot {
+	return Slot{
+		DateTime:        timeBlock.DateTime,
+		DurationMinutes: timeBlock.DurationMinutes,
+		BufferMinutes:   timeBlock.BufferMinutes,
+		RoomID:          timeBlock.RoomID,
+		EquipmentID:     timeBlock.EquipmentID,
+	}
 }
 
 // func CreateDoctorWorkingHours(doctor *Doctor) {
@@ -39,6 +80,10 @@ func CreateTimeBlock(schedule Schedule, appointment Appointment) TimeBlock {
 	return TimeBlock{ScheduleID: schedule.ID, IsAvailable: false, DateTime: appointment.DateTime, Appointment: appointment}
 }
 
-func CreateEmptyTimeBlock(schedule Schedule, dateTime string) TimeBlock {
+func CreateEmptyTimeBlock(schedule Schedule, dateTime DateTime) TimeBlock {
 	return TimeBlock{ScheduleID: schedule.ID, IsAvailable: false, DateTime: dateTime}
 }
+
+func CreateAvailableTimeBlock(schedule Schedule, dateTime DateTime) TimeBlock {
+	return TimeBlock{ScheduleID: schedule.ID, IsAvailable: true, DateTime: dateTime}
+}
@@ -0,0 +1,194 @@
+package Models
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"PhysioUp/Utils/TOTP"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TOTPIssuer        = "PhysioUp"
+	RecoveryCodeCount = 10
+)
+
+var (
+	ErrTOTPInvalid        = errors.New("incorrect authentication code")
+	ErrTOTPNotPending     = errors.New("no two-factor enrolment in progress")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+// RecoveryCode replaces an authenticator code once, for users who lost their
+// phone. Only its hash is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `gorm:"index"`
+	CodeHash string     `gorm:"index"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// TOTPRequired reports whether the user has to pass a second login step,
+// either because they enabled it or because their clinic group enforces it.
+func TOTPRequired(user User) bool {
+	if user.TOTPEnabled {
+		return true
+	}
+	var group ClinicGroup
+	if err := DB.Select("id", "require_totp").First(&group, user.ClinicGroupID).Error; err != nil {
+		return false
+	}
+	return group.RequireTOTP
+}
+
+// BeginTOTPEnrolment stores a new pending secret on the user and returns it
+// with the otpauth URI to show as a QR code. It only takes effect once
+// confirmed with ConfirmTOTPEnrolment.
+func BeginTOTPEnrolment(db *gorm.DB, user User) (string, string, error) {
+	if user.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+	secret, err := TOTP.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := db.Model(&User{}).Where("id = ?", user.ID).Update("totp_secret", secret).Error; err != nil {
+		return "", "", err
+	}
+	return secret, TOTP.URI(TOTPIssuer, user.Username, secret), nil
+}
+
+// ConfirmTOTPEnrolment enables two-factor authentication once the user proves
+// their app generates codes for the pending secret, and returns a fresh set
+// of recovery codes.
+func ConfirmTOTPEnrolment(db *gorm.DB, userID uint, code string) ([]string, error) {
+	var recoveryCodes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return ErrTOTPAlreadyEnabled
+		}
+		if user.TOTPSecret == "" {
+			return ErrTOTPNotPending
+		}
+		step, ok := TOTP.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return ErrTOTPInvalid
+		}
+		if err := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		recoveryCodes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return recoveryCodes, err
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, RecoveryCodeCount)
+	rows := make([]RecoveryCode, RecoveryCodeCount)
+	for index := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[index] = code
+		rows[index] = RecoveryCode{UserID: userID, CodeHash: HashToken(code)}
+	}
+	return codes, tx.Create(&rows).Error
+}
+
+// Recovery codes look like "k7m2-x9qp" and skip letters that read alike
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+func generateRecoveryCode() (string, error) {
+	buffer := make([]byte, 8)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	for index := range buffer {
+		buffer[index] = recoveryAlphabet[int(buffer[index])%len(recoveryAlphabet)]
+	}
+	return string(buffer[:4]) + "-" + string(buffer[4:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+// VerifyUserTOTP checks the second login step, accepting either a code from
+// the user's app or an unused recovery code. Each app code works only once.
+func VerifyUserTOTP(db *gorm.DB, userID uint, code string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		if !user.TOTPEnabled {
+			return ErrTOTPInvalid
+		}
+
+		if step, ok := TOTP.Validate(user.TOTPSecret, code, time.Now()); ok {
+			if step <= user.TOTPLastStep {
+				return ErrTOTPInvalid
+			}
+			return tx.Model(&User{}).Where("id = ?", userID).Update("totp_last_step", step).Error
+		}
+
+		result := tx.Model(&RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashToken(normalizeRecoveryCode(code))).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTOTPInvalid
+		}
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes.
+func RegenerateRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// ResetTOTP turns two-factor authentication off and removes the secret and
+// recovery codes, for users who disable it or lost both their phone and
+// their codes.
+func ResetTOTP(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+}
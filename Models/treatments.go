@@ -15,14 +15,18 @@ type TreatmentPlan struct {
 	PatientID            uint               `json:"patient_id"`
 	PaymentMethod        string             `json:"payment_method"`
 	IsPaid               bool               `json:"is_paid"`
+	ClinicGroupID        uint               `json:"clinic_group_id" gorm:"index"`
 	Appointments         []Appointment
 }
 
 type SuperTreatmentPlan struct {
 	gorm.Model
-	Description    string          `json:"description"`    // Description of the treatment plan
-	SessionsCount  uint            `json:"sessions_count"` // List of sessions in the treatment plan
-	Price          float64         `json:"price"`          // Price of the session
-	TreatmentPlans []TreatmentPlan `json:"treatment_plans"`
-	ClinicGroupID  uint            `json:"clinic_group_id"`
+	Description           string          `json:"description"`     // Description of the treatment plan
+	SessionsCount         uint            `json:"sessions_count"`  // List of sessions in the treatment plan
+	Price                 float64         `json:"price"`           // Price of the session
+	SessionMinutes        uint            `json:"session_minutes"` // 0 uses the therapist's session length
+	RequiresRoom          bool            `json:"requires_room"`
+	RequiredEquipmentType string          `json:"required_equipment_type"` // matches Equipment.Type, empty when none is needed
+	TreatmentPlans        []TreatmentPlan `json:"treatment_plans"`
+	ClinicGroupID         uint            `json:"clinic_group_id"`
 }
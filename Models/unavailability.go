@@ -0,0 +1,105 @@
+package Models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTherapistOnLeave = errors.New("therapist is on leave at this time")
+	ErrClinicClosed     = errors.New("clinic is closed at this time")
+)
+
+// TherapistLeave blocks a therapist's bookings from StartsAt up to, but not
+// including, EndsAt.
+type TherapistLeave struct {
+	gorm.Model
+	TherapistID   uint     `json:"therapist_id" gorm:"index"`
+	StartsAt      DateTime `json:"start"`
+	EndsAt        DateTime `json:"end"`
+	Reason        string   `json:"reason"`
+	ClinicGroupID uint     `json:"clinic_group_id"`
+}
+
+// ClinicClosure blocks bookings with every therapist of a clinic group, for
+// holidays and other closure days.
+type ClinicClosure struct {
+	gorm.Model
+	ClinicGroupID uint     `json:"clinic_group_id" gorm:"index"`
+	StartsAt      DateTime `json:"start"`
+	EndsAt        DateTime `json:"end"`
+	Reason        string   `json:"reason"`
+}
+
+func (leave *TherapistLeave) AfterFind(tx *gorm.DB) error {
+	location := ClinicGroupLocation(leave.ClinicGroupID)
+	leave.StartsAt = leave.StartsAt.InLocation(location)
+	leave.EndsAt = leave.EndsAt.InLocation(location)
+	return nil
+}
+
+func (closure *ClinicClosure) AfterFind(tx *gorm.DB) error {
+	location := ClinicGroupLocation(closure.ClinicGroupID)
+	closure.StartsAt = closure.StartsAt.InLocation(location)
+	closure.EndsAt = closure.EndsAt.InLocation(location)
+	return nil
+}
+
+// Overlaps reports whether the leave intersects the interval from start up
+// to end.
+func (leave TherapistLeave) Overlaps(start time.Time, end time.Time) bool {
+	return leave.StartsAt.Before(end) && leave.EndsAt.After(start)
+}
+
+func (closure ClinicClosure) Overlaps(start time.Time, end time.Time) bool {
+	return closure.StartsAt.Before(end) && closure.EndsAt.After(start)
+}
+
+// CheckBookable returns ErrClinicClosed or ErrTherapistOnLeave when any part
+// of the slot, buffer included, overlaps a closure of the clinic group or a
+// leave of the therapist.
+func CheckBookable(db *gorm.DB, therapistID uint, clinicGroupID uint, slot Slot) error {
+	var count int64
+	if err := db.Model(&ClinicClosure{}).
+		Where("clinic_group_id = ? AND starts_at < ? AND ends_at > ?", clinicGroupID, slot.BusyUntil(), slot.DateTime).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrClinicClosed
+	}
+
+	if err := db.Model(&TherapistLeave{}).
+		Where("therapist_id = ? AND starts_at < ? AND ends_at > ?", therapistID, slot.BusyUntil(), slot.DateTime).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTherapistOnLeave
+	}
+	return nil
+}
+
+// LeaveCollisions lists the open appointments booked with the therapist
+// during the leave.
+func LeaveCollisions(db *gorm.DB, leave TherapistLeave) ([]Appointment, error) {
+	var appointments []Appointment
+	err := db.Model(&Appointment{}).
+		Where("therapist_id = ? AND date_time >= ? AND date_time < ? AND is_completed = ?", leave.TherapistID, leave.StartsAt, leave.EndsAt, false).
+		Order("date_time").
+		Find(&appointments).Error
+	return appointments, err
+}
+
+// ClosureCollisions lists the open appointments of the clinic group booked
+// during the closure.
+func ClosureCollisions(db *gorm.DB, closure ClinicClosure) ([]Appointment, error) {
+	var appointments []Appointment
+	err := db.Model(&Appointment{}).
+		Where("clinic_group_id = ? AND date_time >= ? AND date_time < ? AND is_completed = ?", closure.ClinicGroupID, closure.StartsAt, closure.EndsAt, false).
+		Order("date_time").
+		Find(&appointments).Error
+	return appointments, err
+}
@@ -1,11 +1,11 @@
 package Models
 
 import (
-	"PhysioUp/Utils/Token"
 	"errors"
 	"fmt"
 	"html"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -15,10 +15,25 @@ type User struct {
 	gorm.Model
 	Username      string        `gorm:"size:255;not null;unique" json:"username"`
 	Password      string        `gorm:"size:255;not null;" json:"password"`
-	Permission    int           `json:"permission"`
+	Permission    int           `json:"permission"` // kept in step with Role for older clients
+	Role          Role          `json:"role" gorm:"size:32"`
 	Tokens        []DeviceToken `gorm:"foreignKey:UserID"`
 	IsFrozen      bool          `json:"is_frozen"`
 	ClinicGroupID uint          `json:"clinic_group_id"`
+	Phone         string        `json:"phone"` // password reset codes are sent here over WhatsApp
+	// Set when an owner resets the password, until the user picks their own
+	MustChangePassword bool `json:"must_change_password"`
+	// Self-service password reset code, see IssuePasswordResetCode
+	PasswordResetHash        string     `json:"-"`
+	PasswordResetExpiresAt   *time.Time `json:"-"`
+	PasswordResetAttempts    uint       `json:"-"`
+	PasswordResetSentAt      *time.Time `json:"-"`
+	PasswordResetLockedUntil *time.Time `json:"-"`
+	// Two-factor authentication. The secret is set while enrolling and only
+	// checked at login once TOTPEnabled is set
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPSecret   string `json:"-"`
+	TOTPLastStep int64  `json:"-"` // last accepted code, so a code works only once
 }
 
 type DeviceToken struct {
@@ -109,7 +124,12 @@ func VerifyPassword(password, hashedPassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-func LoginCheck(username string, password string) (uint, string, error) {
+// Unknown usernames are checked against this hash, so they take as long as
+// a wrong password and don't give away which usernames exist
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// LoginCheck returns the ID of the user when the password matches.
+func LoginCheck(username string, password string) (uint, error) {
 
 	var err error
 
@@ -118,22 +138,17 @@ func LoginCheck(username string, password string) (uint, string, error) {
 	err = DB.Model(User{}).Where("username = ?", username).Take(&user).Error
 
 	if err != nil {
-		return 0, "", err
+		VerifyPassword(password, string(dummyPasswordHash))
+		return 0, err
 	}
 
 	err = VerifyPassword(password, user.Password)
 
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", err
-	}
-
-	token, err := Token.GenerateToken(user.ID)
-
 	if err != nil {
-		return 0, "", err
+		return 0, err
 	}
 
-	return user.ID, token, nil
+	return user.ID, nil
 
 }
 
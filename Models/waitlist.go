@@ -0,0 +1,43 @@
+package Models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WaitlistWaiting   = "waiting"
+	WaitlistOffered   = "offered"
+	WaitlistClaimed   = "claimed"
+	WaitlistCancelled = "cancelled"
+)
+
+type WaitlistEntry struct {
+	gorm.Model
+	PatientID          uint             `json:"patient_id"`
+	PatientName        string           `json:"patient_name"`
+	PhoneNumber        string           `json:"phone_number"`
+	TherapistID        *uint            `json:"therapist_id" gorm:"default:null"` // nil means any therapist
+	Windows            []WaitlistWindow `json:"windows" gorm:"constraint:OnDelete:CASCADE;"`
+	Status             string           `json:"status" gorm:"index"`
+	QueuedAt           time.Time        `json:"queued_at"`
+	OfferedTherapistID *uint            `json:"offered_therapist_id" gorm:"default:null"`
+	OfferedDateTime    DateTime         `json:"offered_date_time"`
+	OfferTokenHash     string           `json:"-" gorm:"index"`
+	OfferExpiresAt     *time.Time       `json:"offer_expires_at"`
+	ClinicGroupID      uint             `json:"clinic_group_id"`
+}
+
+// WaitlistWindow is a period in which the patient is able to attend
+type WaitlistWindow struct {
+	gorm.Model
+	WaitlistEntryID uint     `json:"waitlist_entry_id"`
+	StartsAt        DateTime `json:"start"`
+	EndsAt          DateTime `json:"end"`
+}
+
+func (entry *WaitlistEntry) AfterFind(tx *gorm.DB) error {
+	entry.OfferedDateTime = entry.OfferedDateTime.InLocation(ClinicGroupLocation(entry.ClinicGroupID))
+	return nil
+}
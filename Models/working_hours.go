@@ -0,0 +1,123 @@
+package Models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WorkingHoursTemplate describes a recurring weekly availability window for a
+// therapist. Templates are only used to materialise TimeBlocks, so editing
+// them never touches blocks that already exist.
+type WorkingHoursTemplate struct {
+	gorm.Model
+	ScheduleID  uint          `json:"schedule_id"`
+	Weekday     time.Weekday  `json:"weekday"`    // 0 = Sunday ... 6 = Saturday
+	StartTime   string        `json:"start_time"` // "15:04"
+	EndTime     string        `json:"end_time"`   // "15:04"
+	SlotMinutes int           `json:"slot_minutes"`
+	Breaks      []BreakWindow `json:"breaks" gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+type BreakWindow struct {
+	gorm.Model
+	WorkingHoursTemplateID uint   `json:"working_hours_template_id"`
+	StartTime              string `json:"start_time"` // "15:04"
+	EndTime                string `json:"end_time"`   // "15:04"
+}
+
+const templateTimeLayout = "15:04"
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse(templateTimeLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (template *WorkingHoursTemplate) Validate() error {
+	if template.Weekday < time.Sunday || template.Weekday > time.Saturday {
+		return errors.New("weekday must be between 0 (Sunday) and 6 (Saturday)")
+	}
+	if template.SlotMinutes <= 0 {
+		return errors.New("slot length must be greater than zero")
+	}
+	start, err := parseClock(template.StartTime)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(template.EndTime)
+	if err != nil {
+		return err
+	}
+	if start >= end {
+		return errors.New("start time must be before end time")
+	}
+	for _, window := range template.Breaks {
+		breakStart, err := parseClock(window.StartTime)
+		if err != nil {
+			return err
+		}
+		breakEnd, err := parseClock(window.EndTime)
+		if err != nil {
+			return err
+		}
+		if breakStart >= breakEnd {
+			return errors.New("break start time must be before break end time")
+		}
+	}
+	return nil
+}
+
+// Slots returns the start time of every slot the template yields on the given
+// day. Slots that would overlap a break or run past the end time are skipped.
+func (template *WorkingHoursTemplate) Slots(day time.Time) ([]time.Time, error) {
+	if day.Weekday() != template.Weekday {
+		return nil, nil
+	}
+	start, err := parseClock(template.StartTime)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(template.EndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	type window struct{ start, end time.Duration }
+	var breaks []window
+	for _, b := range template.Breaks {
+		breakStart, err := parseClock(b.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		breakEnd, err := parseClock(b.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		breaks = append(breaks, window{breakStart, breakEnd})
+	}
+
+	slotLength := time.Duration(template.SlotMinutes) * time.Minute
+
+	var slots []time.Time
+	for slotStart := start; slotStart+slotLength <= end; slotStart += slotLength {
+		slotEnd := slotStart + slotLength
+		inBreak := false
+		for _, b := range breaks {
+			if slotStart < b.end && b.start < slotEnd {
+				inBreak = true
+				break
+			}
+		}
+		// Built from the wall clock so slots stay put on DST change days
+		if !inBreak {
+			slots = append(slots, time.Date(day.Year(), day.Month(), day.Day(),
+				int(slotStart/time.Hour), int(slotStart%time.Hour/time.Minute), 0, 0, day.Location()))
+		}
+	}
+	return slots, nil
+}
@@ -3,6 +3,7 @@ package Routes
 import (
 	"PhysioUp/Controllers"
 	"PhysioUp/Middleware"
+	"PhysioUp/Models"
 	"PhysioUp/SSE"
 	"PhysioUp/Whatsapp"
 
@@ -13,93 +14,219 @@ import (
 func ConfigRoutes(router *gin.Engine) {
 	// Gzip Compression
 	router.Use(gzip.Gzip(gzip.BestSpeed))
+	router.Use(Middleware.RequestID())
 
 	// Public routes
 	public := router.Group("/api")
 	{
 		public.POST("/login", Controllers.Login)
+		public.POST("/login/totp", Controllers.VerifyLoginTOTP)
+		public.POST("/login/totp/enrol", Controllers.BeginLoginTOTPEnrolment)
+		public.POST("/login/totp/confirm", Controllers.ConfirmLoginTOTPEnrolment)
+		public.POST("/refresh", Controllers.RefreshToken)
+		public.POST("/password/forgot", Controllers.ForgotPassword)
+		public.POST("/password/reset", Controllers.ResetPassword)
 		public.POST("/register", Controllers.Register)
-		public.POST("/register/ClinicGroup", Controllers.RegisterClinicGroup)
+		public.POST("/register/ClinicGroup", Middleware.RequireAdminKey(), Controllers.RegisterClinicGroup)
 		public.POST("/RequestAppointment", Controllers.RequestAppointment)
-		public.POST("/GetPatientIdByPhone", Controllers.GetPatientIdByPhone)
-		public.POST("/FetchAppointmentsByPatientID", Controllers.FetchAppointmentsByPatientID)
-		public.POST("/FetchFutureAppointments", Controllers.FetchFutureAppointments)
 		public.POST("/VerifyAppointmentRequestPhoneNo", Controllers.VerifyAppointmentRequestPhoneNo)
+		public.POST("/ResendAppointmentRequestOTP", Controllers.ResendAppointmentRequestOTP)
+		public.POST("/patient/login", Controllers.PatientLogin)
+		public.POST("/patient/verify", Controllers.VerifyPatientLogin)
 		public.GET("/GetTherapistsTrimmed", Controllers.GetTherapistsTrimmed)
+		public.POST("/ClaimWaitlistOffer", Controllers.ClaimWaitlistOffer)
+		public.GET("/calendar/:therapist_id", Controllers.TherapistCalendarFeed)
+		public.GET("/ManagedAppointment", Controllers.GetManagedAppointment)
+		public.POST("/CancelManagedAppointment", Controllers.CancelManagedAppointment)
+		public.POST("/RescheduleManagedAppointment", Controllers.RescheduleManagedAppointment)
 	}
 
-	// Authorized routes
+	// Public booking routes of one clinic group, resolved from its slug
+	clinic := router.Group("/api/c/:slug")
+	clinic.Use(Middleware.ResolveClinicSlug())
+	{
+		clinic.GET("", Controllers.GetPublicClinicGroup)
+		clinic.GET("/GetTherapistsTrimmed", Controllers.GetTherapistsTrimmed)
+		clinic.POST("/RequestAppointment", Controllers.RequestAppointment)
+		clinic.POST("/JoinWaitlist", Middleware.PatientAuthMiddleware(), Controllers.JoinWaitlist)
+		clinic.POST("/patient/login", Controllers.PatientLogin)
+		clinic.POST("/patient/verify", Controllers.VerifyPatientLogin)
+	}
+
+	// Patient portal routes, restricted to the logged in patient's own data
+	patient := router.Group("/api")
+	patient.Use(Middleware.PatientAuthMiddleware())
+	{
+		patient.POST("/GetPatientIdByPhone", Controllers.GetPatientIdByPhone)
+		patient.POST("/FetchAppointmentsByPatientID", Controllers.FetchAppointmentsByPatientID)
+		patient.POST("/FetchFutureAppointments", Controllers.FetchFutureAppointments)
+		patient.POST("/JoinWaitlist", Controllers.JoinWaitlist)
+	}
+
+	// Routes left to users who still have to change a temporary password
+	account := router.Group("/api/protected")
+	account.Use(Middleware.JwtAuthMiddleware())
+	account.Use(Middleware.SetClinicGroup())
+	{
+		account.POST("/Logout", Controllers.Logout)
+		account.POST("/ChangePassword", Controllers.ChangePassword)
+	}
+
+	// Authorized routes, open to every staff role
 	authorized := router.Group("/api/protected")
 	authorized.Use(Middleware.JwtAuthMiddleware())
 	authorized.Use(Middleware.SetClinicGroup())
+	authorized.Use(Middleware.RequirePasswordChanged())
 	{
-
-		
 		// User-related routes
 		authorized.GET("/user", Controllers.CurrentUser)
 		authorized.POST("/SaveFCM", Controllers.SaveFCM)
+		authorized.GET("/FetchSessions", Controllers.FetchSessions)
+		authorized.POST("/RevokeSession", Controllers.RevokeSession)
+		authorized.POST("/RevokeOtherSessions", Controllers.RevokeOtherSessions)
+		authorized.POST("/SetPhone", Controllers.SetPhone)
 
-		// Appointment-related routes
-		authorized.GET("/FetchRequestedAppointments", Controllers.FetchRequestedAppointments)
-		authorized.GET("/FetchUnassignedAppointments", Controllers.FetchUnassignedAppointments)
-		authorized.POST("/AcceptAppointment", Controllers.AcceptAppointment)
-		authorized.POST("/RegisterAppointment", Controllers.RegisterAppointment)
-		authorized.POST("/RejectAppointment", Controllers.RejectAppointment)
-		authorized.POST("/MarkAppointmentAsCompleted", Controllers.MarkAppointmentAsCompleted)
-		authorized.POST("/UnmarkAppointmentAsCompleted", Controllers.UnmarkAppointmentAsCompleted)
-		authorized.POST("/RemoveAppointmentSendMessage", Controllers.RemoveAppointmentSendMessage)
-
-		// Package-related routes
-		authorized.POST("/FetchPatientCurrentPackage", Controllers.FetchPatientCurrentPackage)
-		authorized.POST("/FetchPatientPackages", Controllers.FetchPatientPackages)
-		authorized.POST("/FetchPackageAppointments", Controllers.FetchPackageAppointments)
-		authorized.POST("/MarkPackageAsPaid", Controllers.MarkPackageAsPaid)
-		authorized.POST("/UnMarkPackageAsPaid", Controllers.UnMarkPackageAsPaid)
-		authorized.POST("/RemovePackage", Controllers.RemovePackage)
-		authorized.POST("/SetPackageReferral", Controllers.SetPackageReferral)
-
-		// Therapist-related routes
-		authorized.POST("/RegisterTherapist", Controllers.RegisterTherapist)
-		authorized.POST("/DeleteTherapist", Controllers.DeleteTherapist)
-		authorized.POST("/GetTherapistSchedule", Controllers.GetTherapistSchedule)
-		authorized.POST("/AddTherapistTimeBlocks", Controllers.AddTherapistTimeBlocks)
-		authorized.GET("/GetTherapists", Controllers.GetTherapists)
-
-		// Patient-related routes
-		authorized.GET("/FetchPatients", Controllers.FetchPatients)
-		authorized.POST("/FetchPatientFilesURLs", Controllers.FetchPatientFilesURLs)
-		authorized.POST("/UploadPatientRecord", Controllers.UploadPatientRecord)
-		authorized.POST("/DeletePatientRecord", Controllers.DeletePatientRecord)
-		authorized.POST("/UpdatePatient", Controllers.UpdatePatient)
-		authorized.POST("/CreatePatient", Controllers.CreatePatient)
-		authorized.POST("/DeletePatient", Controllers.DeletePatient)
-
-		// Referral-related routes
-		authorized.GET("/FetchReferrals", Controllers.FetchReferrals)
-		authorized.POST("/AddReferral", Controllers.AddReferral)
-		authorized.POST("/EditReferral", Controllers.EditReferral)
-		authorized.POST("/DeleteReferral", Controllers.DeleteReferral)
-		authorized.POST("/FetchReferralPackages", Controllers.FetchReferralPackages)
-		authorized.POST("/ExportReferredPackagesExcel", Controllers.ExportReferredPackagesExcel)
-
-		// Super Treatment-related routes
-		authorized.GET("/FetchSuperTreatments", Controllers.FetchSuperTreatments)
-		authorized.POST("/AddSuperTreatment", Controllers.AddSuperTreatment)
-		authorized.POST("/EditSuperTreatment", Controllers.EditSuperTreatment)
-		authorized.POST("/DeleteSuperTreatment", Controllers.DeleteSuperTreatment)
-		// WhatsApp-related routes
-		authorized.GET("/CheckWhatsAppLogin", Whatsapp.CheckLogin)
-		authorized.GET("/GetWhatsAppQRCode", Whatsapp.GetQRCode)
+		// Two-factor authentication
+		authorized.POST("/EnrolTOTP", Controllers.EnrolTOTP)
+		authorized.POST("/ConfirmTOTP", Controllers.ConfirmTOTP)
+		authorized.POST("/RegenerateRecoveryCodes", Controllers.RegenerateRecoveryCodes)
+		authorized.POST("/DisableTOTP", Controllers.DisableTOTP)
 
 		// SSE (Server-Sent Events) route
 		authorized.GET("/RequestSSE", SSE.RequestSSE)
 
-		// Export-related routes
-		authorized.POST("/ExportSalesTable", Controllers.ExportSalesTable)
+		authorized.GET("/GetClinicGroup", Controllers.GetClinicGroup)
+	}
+
+	// The rest of the protected routes are grouped by the permission they need,
+	// see Models.Role for which roles hold it
+
+	// The logged in therapist's own schedule
+	schedule := authorized.Group("", Middleware.RequirePermission(Models.PermissionSchedule))
+	{
+		schedule.POST("/GetTherapistSchedule", Controllers.GetTherapistSchedule)
+		schedule.POST("/AddTherapistTimeBlocks", Controllers.AddTherapistTimeBlocks)
+		schedule.GET("/GetCalendarFeedURL", Controllers.GetCalendarFeedURL)
+		schedule.POST("/RotateCalendarFeed", Controllers.RotateCalendarFeed)
+		schedule.GET("/GetWorkingHoursTemplate", Controllers.GetWorkingHoursTemplate)
+		schedule.POST("/SetWorkingHoursTemplate", Controllers.SetWorkingHoursTemplate)
+		schedule.POST("/GenerateTimeBlocksFromTemplate", Controllers.GenerateTimeBlocksFromTemplate)
+	}
+
+	// Appointment-related routes
+	appointments := authorized.Group("", Middleware.RequirePermission(Models.PermissionAppointments))
+	{
+		appointments.GET("/FetchRequestedAppointments", Controllers.FetchRequestedAppointments)
+		appointments.GET("/FetchUnassignedAppointments", Controllers.FetchUnassignedAppointments)
+		appointments.POST("/AcceptAppointment", Controllers.AcceptAppointment)
+		appointments.POST("/RegisterAppointment", Controllers.RegisterAppointment)
+		appointments.POST("/RejectAppointment", Controllers.RejectAppointment)
+		appointments.POST("/MarkAppointmentAsCompleted", Controllers.MarkAppointmentAsCompleted)
+		appointments.POST("/UnmarkAppointmentAsCompleted", Controllers.UnmarkAppointmentAsCompleted)
+		appointments.POST("/RemoveAppointmentSendMessage", Controllers.RemoveAppointmentSendMessage)
+		appointments.POST("/RescheduleAppointment", Controllers.RescheduleAppointment)
+		appointments.POST("/CreateGroupSession", Controllers.CreateGroupSession)
+		appointments.GET("/FetchWaitlist", Controllers.FetchWaitlist)
+		appointments.POST("/AddWaitlistEntry", Controllers.AddWaitlistEntry)
+		appointments.POST("/RemoveWaitlistEntry", Controllers.RemoveWaitlistEntry)
+		appointments.GET("/FetchTherapistLeaves", Controllers.FetchTherapistLeaves)
+		appointments.GET("/FetchClinicClosures", Controllers.FetchClinicClosures)
+		appointments.GET("/FetchUnavailabilityCollisions", Controllers.FetchUnavailabilityCollisions)
+		appointments.GET("/GetTherapists", Controllers.GetTherapists)
+		appointments.GET("/FetchSuperTreatments", Controllers.FetchSuperTreatments)
+		appointments.GET("/FetchRooms", Controllers.FetchRooms)
+		appointments.GET("/FetchEquipment", Controllers.FetchEquipment)
+		appointments.GET("/FetchBranches", Controllers.FetchBranches)
+	}
+
+	// Leave, closures and session settings of every therapist
+	availability := authorized.Group("", Middleware.RequirePermission(Models.PermissionAvailability))
+	{
+		availability.POST("/AddTherapistLeave", Controllers.AddTherapistLeave)
+		availability.POST("/RemoveTherapistLeave", Controllers.RemoveTherapistLeave)
+		availability.POST("/AddClinicClosure", Controllers.AddClinicClosure)
+		availability.POST("/RemoveClinicClosure", Controllers.RemoveClinicClosure)
+		availability.POST("/UpdateTherapistSessionSettings", Controllers.UpdateTherapistSessionSettings)
+	}
+
+	// Patient-related routes
+	patients := authorized.Group("", Middleware.RequirePermission(Models.PermissionPatients))
+	{
+		patients.GET("/FetchPatients", Controllers.FetchPatients)
+		patients.POST("/FetchPatientFilesURLs", Controllers.FetchPatientFilesURLs)
+		patients.POST("/UploadPatientRecord", Controllers.UploadPatientRecord)
+		patients.POST("/DeletePatientRecord", Controllers.DeletePatientRecord)
+		patients.POST("/UpdatePatient", Controllers.UpdatePatient)
+		patients.POST("/CreatePatient", Controllers.CreatePatient)
+		patients.Static("/PatientRecords", "./PatientRecords")
+	}
+	authorized.POST("/DeletePatient", Middleware.RequirePermission(Models.PermissionDeletePatients), Controllers.DeletePatient)
+
+	// Package and referral-related routes
+	billing := authorized.Group("", Middleware.RequirePermission(Models.PermissionBilling))
+	{
+		billing.POST("/FetchPatientCurrentPackage", Controllers.FetchPatientCurrentPackage)
+		billing.POST("/FetchPatientPackages", Controllers.FetchPatientPackages)
+		billing.POST("/FetchPackageAppointments", Controllers.FetchPackageAppointments)
+		billing.POST("/MarkPackageAsPaid", Controllers.MarkPackageAsPaid)
+		billing.POST("/UnMarkPackageAsPaid", Controllers.UnMarkPackageAsPaid)
+		billing.POST("/RemovePackage", Controllers.RemovePackage)
+		billing.POST("/SetPackageReferral", Controllers.SetPackageReferral)
+		billing.GET("/FetchReferrals", Controllers.FetchReferrals)
+		billing.POST("/AddReferral", Controllers.AddReferral)
+		billing.POST("/EditReferral", Controllers.EditReferral)
+		billing.POST("/DeleteReferral", Controllers.DeleteReferral)
+		billing.POST("/FetchReferralPackages", Controllers.FetchReferralPackages)
+	}
+
+	// Export-related routes
+	reports := authorized.Group("", Middleware.RequirePermission(Models.PermissionReports))
+	{
+		reports.POST("/ExportSalesTable", Controllers.ExportSalesTable)
+		reports.POST("/ExportReferredPackagesExcel", Controllers.ExportReferredPackagesExcel)
+		reports.GET("/GetBranchReport", Controllers.GetBranchReport)
+	}
+
+	// Clinic set up
+	clinicAdmin := authorized.Group("", Middleware.RequirePermission(Models.PermissionClinic))
+	{
+		clinicAdmin.POST("/UpdateClinicGroupSettings", Controllers.UpdateClinicGroupSettings)
+		clinicAdmin.POST("/RegisterTherapist", Controllers.RegisterTherapist)
+		clinicAdmin.POST("/DeleteTherapist", Controllers.DeleteTherapist)
+		clinicAdmin.POST("/AssignTherapistBranch", Controllers.AssignTherapistBranch)
+		clinicAdmin.POST("/AddSuperTreatment", Controllers.AddSuperTreatment)
+		clinicAdmin.POST("/EditSuperTreatment", Controllers.EditSuperTreatment)
+		clinicAdmin.POST("/DeleteSuperTreatment", Controllers.DeleteSuperTreatment)
+		clinicAdmin.POST("/AddRoom", Controllers.AddRoom)
+		clinicAdmin.POST("/RemoveRoom", Controllers.RemoveRoom)
+		clinicAdmin.POST("/AddEquipment", Controllers.AddEquipment)
+		clinicAdmin.POST("/RemoveEquipment", Controllers.RemoveEquipment)
+		clinicAdmin.POST("/AddBranch", Controllers.AddBranch)
+		clinicAdmin.POST("/EditBranch", Controllers.EditBranch)
+		clinicAdmin.POST("/RemoveBranch", Controllers.RemoveBranch)
+
+		// WhatsApp-related routes
+		clinicAdmin.GET("/CheckWhatsAppLogin", Whatsapp.CheckLogin)
+		clinicAdmin.GET("/GetWhatsAppQRCode", Whatsapp.GetQRCode)
+	}
+
+	// Role management and invites
+	staff := authorized.Group("", Middleware.RequirePermission(Models.PermissionStaff))
+	{
+		staff.GET("/FetchRoles", Controllers.FetchRoles)
+		staff.GET("/FetchStaff", Controllers.FetchStaff)
+		staff.POST("/AssignRole", Controllers.AssignRole)
+		staff.POST("/SetUserFrozen", Controllers.SetUserFrozen)
+		staff.POST("/ResetUserTOTP", Controllers.ResetUserTOTP)
+		staff.POST("/ResetUserPassword", Controllers.ResetUserPassword)
+		staff.GET("/FetchLoginAttempts", Controllers.FetchLoginAttempts)
+		staff.GET("/FetchAuditLogs", Controllers.FetchAuditLogs)
+		staff.GET("/FetchInvites", Controllers.FetchInvites)
+		staff.POST("/CreateInvite", Controllers.CreateInvite)
+		staff.POST("/RevokeInvite", Controllers.RevokeInvite)
 	}
 
 	// Static file serving
-	authorized.Static("/PatientRecords", "./PatientRecords")
 	router.Static("/Web", "./Static")
 	router.Static("/Welcome", "./Welcome")
 }
@@ -0,0 +1,89 @@
+package ICal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+const utcLayout = "20060102T150405Z"
+
+// Event is a single VEVENT. UID must stay the same for the lifetime of the
+// event and Sequence must grow whenever it changes, so calendar clients
+// replace their copy instead of adding a duplicate.
+type Event struct {
+	UID          string
+	Summary      string
+	Description  string
+	Start        time.Time
+	End          time.Time
+	Status       string
+	Sequence     int64
+	LastModified time.Time
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// Render returns an RFC 5545 calendar holding the events.
+func Render(name string, events []Event) string {
+	var builder strings.Builder
+	now := time.Now().UTC().Format(utcLayout)
+
+	writeLine(&builder, "BEGIN:VCALENDAR")
+	writeLine(&builder, "VERSION:2.0")
+	writeLine(&builder, "PRODID:-//PhysioUp//Therapist Schedule//EN")
+	writeLine(&builder, "CALSCALE:GREGORIAN")
+	writeLine(&builder, "METHOD:PUBLISH")
+	writeLine(&builder, "X-WR-CALNAME:"+textEscaper.Replace(name))
+	writeLine(&builder, "REFRESH-INTERVAL;VALUE=DURATION:PT15M")
+	writeLine(&builder, "X-PUBLISHED-TTL:PT15M")
+
+	for _, event := range events {
+		status := event.Status
+		if status == "" {
+			status = StatusConfirmed
+		}
+		writeLine(&builder, "BEGIN:VEVENT")
+		writeLine(&builder, "UID:"+event.UID)
+		writeLine(&builder, "DTSTAMP:"+now)
+		writeLine(&builder, "DTSTART:"+event.Start.UTC().Format(utcLayout))
+		writeLine(&builder, "DTEND:"+event.End.UTC().Format(utcLayout))
+		writeLine(&builder, fmt.Sprintf("SEQUENCE:%d", event.Sequence))
+		if !event.LastModified.IsZero() {
+			writeLine(&builder, "LAST-MODIFIED:"+event.LastModified.UTC().Format(utcLayout))
+		}
+		writeLine(&builder, "STATUS:"+status)
+		writeLine(&builder, "SUMMARY:"+textEscaper.Replace(event.Summary))
+		if event.Description != "" {
+			writeLine(&builder, "DESCRIPTION:"+textEscaper.Replace(event.Description))
+		}
+		writeLine(&builder, "END:VEVENT")
+	}
+
+	writeLine(&builder, "END:VCALENDAR")
+	return builder.String()
+}
+
+// writeLine folds content lines longer than 75 octets without splitting a
+// UTF-8 sequence and terminates them with CRLF.
+func writeLine(builder *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		builder.WriteString(line[:cut])
+		builder.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space
+		limit = 74
+	}
+	builder.WriteString(line)
+	builder.WriteString("\r\n")
+}
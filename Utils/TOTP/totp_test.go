@@ -0,0 +1,111 @@
+package TOTP
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// The SHA-1 seed of the RFC 6238 test vectors, "12345678901234567890"
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// The SHA-1 vectors of RFC 6238 appendix B, cut to the last 6 of their 8
+// digits the way the truncation of RFC 4226 works.
+var rfcVectors = []struct {
+	unix int64
+	step int64
+	code string
+}{
+	{59, 0x1, "287082"},                 // 94287082
+	{1111111109, 0x23523EC, "081804"},   // 07081804
+	{1111111111, 0x23523ED, "050471"},   // 14050471
+	{1234567890, 0x273EF07, "005924"},   // 89005924
+	{2000000000, 0x3F940AA, "279037"},   // 69279037
+	{20000000000, 0x27BC86AA, "353130"}, // 65353130
+}
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	for _, vector := range rfcVectors {
+		at := time.Unix(vector.unix, 0)
+		if step := Step(at); step != vector.step {
+			t.Errorf("Step(%d) = %X, want %X", vector.unix, step, vector.step)
+		}
+		code, err := Code(rfcSecret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != vector.code {
+			t.Errorf("Code at %d = %s, want %s", vector.unix, code, vector.code)
+		}
+		if step, ok := Validate(rfcSecret, vector.code, at); !ok || step != vector.step {
+			t.Errorf("Validate at %d = %X, %v, want %X, true", vector.unix, step, ok, vector.step)
+		}
+	}
+}
+
+// Codes of the steps right before and after the current one are accepted,
+// and none further away.
+func TestValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	cases := []struct {
+		offset time.Duration
+		valid  bool
+	}{
+		{-2 * Period, false},
+		{-Period, true},
+		{0, true},
+		{Period, true},
+		{2 * Period, false},
+	}
+	for _, tc := range cases {
+		code, err := Code(rfcSecret, now.Add(tc.offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != tc.valid {
+			t.Errorf("code from %v away: valid = %v, want %v", tc.offset, ok, tc.valid)
+			continue
+		}
+		if ok && step != Step(now.Add(tc.offset)) {
+			t.Errorf("code from %v away: matched step %d, want %d", tc.offset, step, Step(now.Add(tc.offset)))
+		}
+	}
+}
+
+// A code reports the step it belongs to however late in the window it is
+// checked, so callers that remember the last used step refuse it a second
+// time.
+func TestValidateReportsTheSameStepForAReusedCode(t *testing.T) {
+	issued := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, ok := Validate(rfcSecret, code, issued)
+	if !ok {
+		t.Fatal("code not accepted when issued")
+	}
+	reused, ok := Validate(rfcSecret, code, issued.Add(Period))
+	if !ok {
+		t.Fatal("code not accepted a step later")
+	}
+	if reused != first {
+		t.Fatalf("reused code matched step %d, want %d", reused, first)
+	}
+}
+
+func TestValidateRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, input := range []string{"", "28708", "2870822", "94287082", "abcdef"} {
+		if _, ok := Validate(rfcSecret, input, now); ok {
+			t.Errorf("Validate accepted %q", input)
+		}
+	}
+	if _, ok := Validate("not base32!", "287082", now); ok {
+		t.Error("Validate accepted an invalid secret")
+	}
+	if _, ok := Validate(rfcSecret, " 287 082 ", now); !ok {
+		t.Error("Validate refused a code with spaces")
+	}
+}
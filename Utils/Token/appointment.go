@@ -0,0 +1,47 @@
+package Token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const manageAppointmentScope = "manage_appointment"
+
+// GenerateAppointmentToken signs a link token that lets a patient manage one
+// appointment without logging in.
+func GenerateAppointmentToken(appointmentID uint, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{}
+	claims["scope"] = manageAppointmentScope
+	claims["appointment_id"] = appointmentID
+	claims["exp"] = expiresAt.Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+}
+
+// ParseAppointmentToken returns the appointment a link token was issued for.
+func ParseAppointmentToken(tokenString string) (uint, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("API_SECRET")), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["scope"] != manageAppointmentScope {
+		return 0, errors.New("invalid appointment token")
+	}
+	id, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["appointment_id"]), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
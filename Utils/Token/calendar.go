@@ -0,0 +1,24 @@
+package Token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// CalendarFeedToken signs a therapist's calendar secret. Rotating the secret
+// invalidates every feed URL handed out before.
+func CalendarFeedToken(therapistID uint, secret string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("API_SECRET")))
+	fmt.Fprintf(mac, "calendar:%d:%s", therapistID, secret)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CalendarFeedTokenValid(therapistID uint, secret string, token string) bool {
+	if secret == "" || token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(CalendarFeedToken(therapistID, secret)), []byte(token))
+}
@@ -0,0 +1,51 @@
+package Token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	loginChallengeScope    = "login_2fa"
+	loginChallengeLifespan = 5 * time.Minute
+)
+
+// GenerateLoginChallengeToken signs a short lived token proving the user got
+// their password right. It's exchanged for a session once the second login
+// step passes and can't be used on staff routes.
+func GenerateLoginChallengeToken(user_id uint) (string, error) {
+	claims := jwt.MapClaims{}
+	claims["scope"] = loginChallengeScope
+	claims["user_id"] = user_id
+	claims["exp"] = time.Now().Add(loginChallengeLifespan).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+}
+
+// ParseLoginChallengeToken returns the user of a login challenge token.
+func ParseLoginChallengeToken(tokenString string) (uint, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(os.Getenv("API_SECRET")), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["scope"] != loginChallengeScope {
+		return 0, errors.New("not a login token")
+	}
+	id, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["user_id"]), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
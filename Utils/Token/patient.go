@@ -0,0 +1,48 @@
+package Token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const patientScope = "patient"
+
+// GeneratePatientToken signs a token for the patient portal. It can't be used
+// on staff routes.
+func GeneratePatientToken(patientID uint) (string, error) {
+	token_lifespan, err := strconv.Atoi(os.Getenv("TOKEN_DAY_LIFESPAN"))
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{}
+	claims["scope"] = patientScope
+	claims["patient_id"] = patientID
+	claims["exp"] = time.Now().Add(time.Hour * 24 * time.Duration(token_lifespan)).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+}
+
+// ExtractPatientID returns the patient of a patient portal token.
+func ExtractPatientID(c *gin.Context) (uint, error) {
+	token, err := ExtractJWT(c)
+	if err != nil {
+		return 0, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["scope"] != patientScope {
+		return 0, errors.New("not a patient token")
+	}
+	id, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["patient_id"]), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
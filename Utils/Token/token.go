@@ -1,6 +1,7 @@
 package Token
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -11,26 +12,46 @@ import (
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateToken(user_id uint) (string, error) {
-	token_lifespan, err := strconv.Atoi(os.Getenv("TOKEN_DAY_LIFESPAN"))
+// Access tokens are short lived and tied to the session their refresh token
+// belongs to, so revoking the session locks them out too
+const defaultAccessTokenMinutes = 15
+
+func accessTokenLifespan() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = defaultAccessTokenMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
 
+// RefreshTokenLifespan is how long a session lasts without being refreshed.
+func RefreshTokenLifespan() (time.Duration, error) {
+	token_lifespan, err := strconv.Atoi(os.Getenv("TOKEN_DAY_LIFESPAN"))
 	if err != nil {
-		return "", err
+		return 0, err
 	}
+	return time.Hour * 24 * time.Duration(token_lifespan), nil
+}
+
+// GenerateToken signs an access token for the user's session and returns it
+// with its lifespan.
+func GenerateToken(user_id uint, session_id uint) (string, time.Duration, error) {
+	lifespan := accessTokenLifespan()
 
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = user_id
-	claims["exp"] = time.Now().Add(time.Hour * 24 * time.Duration(token_lifespan)).Unix()
+	claims["session_id"] = session_id
+	claims["exp"] = time.Now().Add(lifespan).Unix()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
-
+	signed, err := token.SignedString([]byte(os.Getenv("API_SECRET")))
+	return signed, lifespan, err
 }
 
 func TokenValid(c *gin.Context) error {
 	tokenString := ExtractToken(c)
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
@@ -39,6 +60,11 @@ func TokenValid(c *gin.Context) error {
 	if err != nil {
 		return err
 	}
+	// Patient and link tokens carry a scope and aren't valid for staff routes,
+	// and tokens from before sessions existed can't be revoked
+	if claims, ok := token.Claims.(jwt.MapClaims); !ok || claims["scope"] != nil || claims["session_id"] == nil {
+		return errors.New("token not valid for this route")
+	}
 	return nil
 }
 
@@ -89,3 +115,20 @@ func ExtractTokenID(c *gin.Context) (uint, error) {
 	}
 	return 0, nil
 }
+
+// ExtractSessionID returns the session of a staff access token.
+func ExtractSessionID(c *gin.Context) (uint, error) {
+	token, err := ExtractJWT(c)
+	if err != nil {
+		return 0, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["session_id"] == nil {
+		return 0, errors.New("not a session token")
+	}
+	id, err := strconv.ParseUint(fmt.Sprintf("%.0f", claims["session_id"]), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
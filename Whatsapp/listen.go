@@ -116,7 +116,6 @@ func SendMessage(phone, message string) error {
 
 	urlLogin := Constants.WhatsappGoService + "/send/message"
 	dataStr := fmt.Sprintf(`{"phone": "%s", "message": "%s"}`, phone, message)
-	fmt.Println(dataStr)
 	data := []byte(dataStr)
 	req, err := http.NewRequest(method, urlLogin, bytes.NewBuffer(data))
 
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"log"
+	"os"
+	"strings"
+
 	"PhysioUp/CronJobs"
 	"PhysioUp/FirebaseMessaging"
 	"PhysioUp/Models"
@@ -14,6 +18,17 @@ func main() {
 	Models.ConnectDataBase()
 	FirebaseMessaging.Setup()
 	router := gin.Default()
+	// Only the proxies in TRUSTED_PROXIES (comma separated IPs or CIDRs) may
+	// set the client IP through X-Forwarded-For, none when it is unset
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"https://physioup.ddns.net", "http://localhost:3000"}, // Replace with your frontend URL
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},